@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type changePasswordRequest struct {
+	Id     string `json:"id"`
+	Pwd    string `json:"pwd"`
+	NewPwd string `json:"new_pwd"`
+}
+
+type changeEmailRequest struct {
+	Id    string `json:"id"`
+	Pwd   string `json:"pwd"`
+	Email string `json:"email"`
+}
+
+// whether pwd is the user's current password, also returns their email
+func checkPassword(userId string, pwd string) (string, bool, error) {
+	var email, salt, recorded string
+	err := dataBase.QueryRow(`SELECT email, salt, pwd FROM auth WHERE id = $1;`, userId).Scan(&email, &salt, &recorded)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return email, hashPassword(pwd, salt) == recorded, nil
+}
+
+// check the password a handler was given, responds and returns false when it is wrong or the account is locked out.
+// wrong passwords count as failed logins for the account. also returns the user's email.
+func confirmPassword(context *gin.Context, userId string, pwd string) (string, bool) {
+	email, ok, err := checkPassword(userId, pwd)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{userId}}))
+		return "", false
+	}
+
+	// locked out accounts get the same answer whether or not the password is right
+	if email != "" && throttled(context, loginThrottle, accountKey(email)) {
+		return "", false
+	}
+	if !ok {
+		if email != "" {
+			if err = loginThrottle.fail(time.Now(), accountKey(email)); err != nil {
+				log.Println(err)
+			}
+		}
+		context.IndentedJSON(http.StatusExpectationFailed, newResponse("pwd incorrect", []interface{}{id{userId}}))
+		return "", false
+	}
+	return email, true
+}
+
+// set a new password, the current one is required
+func changePassword(context *gin.Context) {
+	var request changePasswordRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if request.NewPwd == "" {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("new_pwd required", []interface{}{id{request.Id}}))
+		return
+	}
+
+	email, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	defer tx.Rollback()
+
+	salt := generateSalt()
+	_, err = tx.Exec(`UPDATE auth SET salt = $1, pwd = $2 WHERE id = $3;`, salt, hashPassword(request.NewPwd, salt), request.Id)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	// whoever was signed in with the old password is signed out
+	if err = revokeSessions(tx, request.Id); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	server.disconnect(request.Id)
+
+	body := "The password of your Hot & Cold account was changed.\n\n" +
+		"If this was not you, reset your password straight away.\n"
+	if err = mailer.Send(email, "Your password was changed", body); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{id{request.Id}}))
+}
+
+// start moving the account to a new email, it takes effect once the new address is verified
+func changeEmail(context *gin.Context) {
+	var request changeEmailRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if !validEmail(request.Email) {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid email", []interface{}{id{request.Id}}))
+		return
+	}
+
+	oldEmail, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	var taken int
+	err := dataBase.QueryRow(`SELECT COUNT(*) FROM auth WHERE email = $1;`, request.Email).Scan(&taken)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	if taken > 0 {
+		context.IndentedJSON(http.StatusConflict, newResponse("Email exists!", []interface{}{id{request.Id}}))
+		return
+	}
+
+	userId, err := strconv.Atoi(request.Id)
+	if err != nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	if err = sendVerification(userId, request.Email); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	body := "Someone asked to move your Hot & Cold account to " + request.Email + ".\n\n" +
+		"The change happens once that address is verified. If this was not you, change your password straight away.\n"
+	if err = mailer.Send(oldEmail, "Your email is being changed", body); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{id{request.Id}}))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// actions on the whole account need the password
+type accountRequest struct {
+	Id  string `json:"id"`
+	Pwd string `json:"pwd"`
+}
+
+type accountDeletion struct {
+	Id          string    `json:"id"`
+	RequestedAt time.Time `json:"requested_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+// statements that remove a user from every table, run in order in one transaction with the user id as $1.
+// each statement only compares $1 with columns of one type, so postgres can infer it.
+var accountDeletionStatements = []string{
+	// direct messages stay for the other side, the user's own become tombstones like unsent ones
+	`DELETE FROM message_edits WHERE msg_id IN (SELECT msg_id FROM messaage WHERE id_from = $1);`,
+	`UPDATE messaage SET msg = '', deleted_at = COALESCE(deleted_at, current_timestamp) WHERE id_from = $1;`,
+	`DELETE FROM read_cursors WHERE id = $1;`,
+
+	// events they own go, with a tombstone for calendar feeds, their chats, occurrences and reminders cascade
+	`WITH removed AS (
+		DELETE FROM events WHERE owner = $1
+		RETURNING event_id, user_id, name, datetime, rrule, sequence
+	)
+	INSERT INTO removed_events (event_id, user_id, name, datetime, rrule, sequence, removed_at)
+	SELECT event_id, user_id, name, datetime, rrule, sequence + 1, current_timestamp FROM removed;`,
+	// events they joined, and the tombstones above, lose them as a participant
+	`UPDATE events SET user_id = array_remove(user_id, $1::bigint), sequence = sequence + 1 WHERE $1::bigint = ANY(user_id);`,
+	`UPDATE event_occurrences SET user_id = array_remove(user_id, $1::bigint) WHERE $1::bigint = ANY(user_id);`,
+	`UPDATE removed_events SET user_id = array_remove(user_id, $1::bigint) WHERE $1::bigint = ANY(user_id);`,
+	`DELETE FROM event_messages WHERE id_from = $1;`,
+
+	`DELETE FROM notifications WHERE id = $1;`,
+	`DELETE FROM notification_prefs WHERE id = $1;`,
+	`DELETE FROM device_tokens WHERE id = $1;`,
+	`DELETE FROM calendar_tokens WHERE id = $1;`,
+	`DELETE FROM moderation_queue WHERE user_id = $1;`,
+
+	`DELETE FROM tags WHERE id = $1;`,
+	`DELETE FROM geog WHERE id = $1;`,
+	`DELETE FROM encounter WHERE id = $1 OR oppid = $1;`,
+	`DELETE FROM interested WHERE id_from = $1 OR id_to = $1;`,
+	`DELETE FROM not_interested WHERE id_from = $1 OR id_to = $1;`,
+	`DELETE FROM reported WHERE from_id = $1 OR to_id = $1;`,
+
+	`DELETE FROM password_resets WHERE id = $1;`,
+	`DELETE FROM email_verifications WHERE id = $1;`,
+	`DELETE FROM totp WHERE id = $1;`,
+	`DELETE FROM recovery_codes WHERE id = $1;`,
+	`DELETE FROM login_challenges WHERE id = $1;`,
+	`DELETE FROM identities WHERE id = $1;`,
+	`DELETE FROM account_deletions WHERE id = $1;`,
+	`DELETE FROM profile WHERE id = $1;`,
+	`DELETE FROM auth WHERE id = $1;`,
+}
+
+// remove a user and everything tied to them, files are deleted once the transaction has committed
+func deleteAccount(ctx context.Context, userId string) error {
+	tx, err := dataBase.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// attachments they uploaded, those sent to them stay with the sender
+	var keys []string
+	rows, err := tx.QueryContext(ctx, `DELETE FROM attachments WHERE owner = $1 RETURNING blob_key;`, userId)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, statement := range accountDeletionStatements {
+		if _, err = tx.ExecContext(ctx, statement, userId); err != nil {
+			return err
+		}
+	}
+	if err = revokeSessions(tx, userId); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	server.disconnect(userId)
+	deleteBlobs(keys)
+	return nil
+}
+
+// delete the accounts whose grace period has passed
+func purgeDeletedAccounts(ctx context.Context) error {
+	rows, err := dataBase.QueryContext(ctx, `SELECT id FROM account_deletions WHERE delete_after < current_timestamp;`)
+	if err != nil {
+		return err
+	}
+
+	var due []string
+	for rows.Next() {
+		var userId string
+		if err = rows.Scan(&userId); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, userId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// one account failing does not hold up the others, it is tried again on the next run
+	failed := 0
+	for _, userId := range due {
+		if err = deleteAccount(ctx, userId); err != nil {
+			log.Printf("deleting account %s: %s", userId, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d accounts could not be deleted", failed, len(due))
+	}
+	return nil
+}
+
+// schedule the account for deletion after ACCOUNT_DELETION_GRACE, it can be cancelled until then
+func requestAccountDeletion(context *gin.Context) {
+	var request accountRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	email, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	grace := envDuration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour)
+	var temp accountDeletion
+	err := dataBase.QueryRow(`
+		INSERT INTO account_deletions (id, requested_at, delete_after)
+		VALUES ($1, current_timestamp, $2)
+		ON CONFLICT (id) DO UPDATE SET id = EXCLUDED.id
+		RETURNING id, requested_at, delete_after;
+	`, request.Id, time.Now().Add(grace)).Scan(&temp.Id, &temp.RequestedAt, &temp.DeleteAfter)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	body := "Your Hot & Cold account will be deleted on " + temp.DeleteAfter.UTC().Format("2 January 2006") + ".\n\n" +
+		"Until then you can cancel the deletion from the app. If this was not you, change your password straight away.\n"
+	if err = mailer.Send(email, "Your account will be deleted", body); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
+}
+
+func cancelAccountDeletion(context *gin.Context) {
+	var request accountRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	var temp accountDeletion
+	err := dataBase.QueryRow(`
+		DELETE FROM account_deletions WHERE id = $1
+		RETURNING id, requested_at, delete_after;
+	`, request.Id).Scan(&temp.Id, &temp.RequestedAt, &temp.DeleteAfter)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("no deletion pending", []interface{}{id{request.Id}}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// every table must be cleared when an account is deleted, new tables have to be added to accountDeletionStatements
+func TestAccountDeletionCoversEveryTable(t *testing.T) {
+	schema, err := os.ReadFile("schema.sql")
+	assert.NoError(t, err)
+
+	// tables that existed before schema.sql
+	tables := []string{"auth", "profile", "tags", "geog", "encounter", "interested", "not_interested", "messaage", "events", "reported"}
+	for _, match := range regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`).FindAllStringSubmatch(string(schema), -1) {
+		tables = append(tables, match[1])
+	}
+
+	skipped := map[string]string{
+		"jobs":            "not tied to a user",
+		"event_reminders": "cascades with events",
+		"attachments":     "deleted first by deleteAccount, so the files can be removed",
+		"sessions":        "revoked by deleteAccount",
+	}
+
+	statements := strings.Join(accountDeletionStatements, "\n")
+	for _, table := range tables {
+		if _, ok := skipped[table]; ok {
+			continue
+		}
+		assert.Regexp(t, `(DELETE FROM|UPDATE|INSERT INTO) `+table+`\b`, statements, "table %s is not cleared", table)
+	}
+}
+
+func TestDeleteAccountKeepsPeerMessages(t *testing.T) {
+	requireDatabase(t)
+
+	leaving := createTestProfile(t, "deletion-test-leaving@example.com", "Leaving")
+	staying := createTestProfile(t, "deletion-test-staying@example.com", "Staying")
+	t.Cleanup(func() {
+		dataBase.Exec(`DELETE FROM messaage WHERE id_from = $1 OR id_to = $1;`, staying)
+		dataBase.Exec(`DELETE FROM read_cursors WHERE id = $1;`, staying)
+	})
+
+	sent := insertTestMessage(t, leaving, staying, "hi")
+	received := insertTestMessage(t, staying, leaving, "hello")
+	_, err := dataBase.Exec(`INSERT INTO message_edits (msg_id, msg, edited_at) VALUES ($1, 'hey', current_timestamp);`, sent)
+	assert.NoError(t, err)
+	assert.NoError(t, updateReadCursor(staying, leaving, sent))
+
+	assert.NoError(t, deleteAccount(context.Background(), leaving))
+
+	// the other side keeps the conversation, without the leaving user's words
+	var m message
+	assert.NoError(t, scanMessage(dataBase.QueryRow(`SELECT `+messageColumns+` FROM messaage WHERE msg_id = $1;`, sent), &m))
+	assert.Equal(t, "", m.Msg)
+	assert.NotNil(t, m.DeletedAt)
+	assert.NoError(t, scanMessage(dataBase.QueryRow(`SELECT `+messageColumns+` FROM messaage WHERE msg_id = $1;`, received), &m))
+	assert.Equal(t, "hello", m.Msg)
+	assert.Nil(t, m.DeletedAt)
+
+	var edits, cursors int
+	dataBase.QueryRow(`SELECT count(*) FROM message_edits WHERE msg_id = $1;`, sent).Scan(&edits)
+	dataBase.QueryRow(`SELECT count(*) FROM read_cursors WHERE id = $1;`, staying).Scan(&cursors)
+	assert.Equal(t, 0, edits)
+	assert.Equal(t, 1, cursors)
+}
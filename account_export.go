@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a file in the data export and the query filling it, with the user id as $1,
+// as with accountDeletionStatements each query only compares $1 with columns of one type
+type exportSection struct {
+	name  string
+	query string
+}
+
+// everything tied to a user, secrets such as password hashes and tokens are left out
+var accountExportSections = []exportSection{
+	{"account.json", `SELECT id, email, verified, is_admin FROM auth WHERE id = $1;`},
+	{"profile.json", `SELECT id, name, age, bio, pfp FROM profile WHERE id = $1;`},
+	{"tags.json", `SELECT tag FROM tags WHERE id = $1;`},
+	{"location.json", `SELECT ST_AsText(point) AS point, time FROM geog WHERE id = $1;`},
+	{"encounters.json", `SELECT * FROM encounter WHERE id = $1 OR oppid = $1;`},
+	{"interested.json", `SELECT id_to FROM interested WHERE id_from = $1;`},
+	{"not_interested.json", `SELECT id_to, created_at FROM not_interested WHERE id_from = $1;`},
+	{"reported.json", `SELECT to_id FROM reported WHERE from_id = $1;`},
+	{"messages.json", `SELECT ` + messageColumns + ` FROM messaage WHERE id_from = $1 OR id_to = $1 ORDER BY time_sent;`},
+	{"message_edits.json", `SELECT * FROM message_edits WHERE msg_id IN (SELECT msg_id FROM messaage WHERE id_from = $1) ORDER BY msg_id;`},
+	{"attachments.json", `SELECT ` + attachmentColumns + `, msg_id FROM attachments WHERE owner = $1 ORDER BY attachment_id;`},
+	{"events_owned.json", `SELECT ` + eventColumns + ` FROM events WHERE owner = $1 ORDER BY datetime;`},
+	{"events_joined.json", `SELECT ` + eventColumns + ` FROM events WHERE $1::bigint = ANY(user_id) ORDER BY datetime;`},
+	{"event_messages.json", `SELECT msg_id, event_id, msg, time_sent FROM event_messages WHERE id_from = $1 ORDER BY time_sent;`},
+	{"notifications.json", `SELECT notification_id, kind, title, msg, time_sent FROM notifications WHERE id = $1 ORDER BY time_sent;`},
+	{"notification_prefs.json", `SELECT messages, matches, events, quiet_start, quiet_end, utc_offset FROM notification_prefs WHERE id = $1;`},
+	{"devices.json", `SELECT platform, created_at FROM device_tokens WHERE id = $1;`},
+	{"identities.json", `SELECT provider, email, created_at FROM identities WHERE id = $1;`},
+	{"account_deletion.json", `SELECT requested_at, delete_after FROM account_deletions WHERE id = $1;`},
+}
+
+// write every row as a JSON object keyed by column name
+func writeRowsJSON(w io.Writer, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// text and array columns come back as bytes
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(result)
+}
+
+// copy the files of a user's attachments into the export
+func writeAttachmentFiles(archive *zip.Writer, userId string) error {
+	rows, err := dataBase.Query(`SELECT attachment_id, content_type, blob_key FROM attachments WHERE owner = $1;`, userId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachmentId int
+		var contentType, key string
+		if err = rows.Scan(&attachmentId, &contentType, &key); err != nil {
+			return err
+		}
+
+		name := "attachments/" + strconv.Itoa(attachmentId)
+		if extensions, _ := mime.ExtensionsByType(contentType); len(extensions) > 0 {
+			name += extensions[0]
+		}
+
+		f, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		blob, err := blobs.Get(key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// download everything tied to the account as a zip of JSON files, with the attached images
+func exportAccount(context *gin.Context) {
+	var request accountRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	context.Header("Content-Type", "application/zip")
+	context.Header("Content-Disposition", `attachment; filename="hotandcold-`+request.Id+`.zip"`)
+	context.Status(http.StatusOK)
+
+	// the status is sent by now, so errors past this point can only cut the download short
+	var err error
+	archive := zip.NewWriter(context.Writer)
+	for _, section := range accountExportSections {
+		var f io.Writer
+		f, err = archive.Create(section.name)
+		if err != nil {
+			break
+		}
+
+		var rows *sql.Rows
+		rows, err = dataBase.Query(section.query, request.Id)
+		if err != nil {
+			break
+		}
+		err = writeRowsJSON(f, rows)
+		rows.Close()
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writeAttachmentFiles(archive, request.Id)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+}
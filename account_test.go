@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func accountRouter() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.PATCH("/password", changePassword)
+	router.PATCH("/email", changeEmail)
+	return router
+}
+
+func countSessions(t *testing.T, userId string) int {
+	t.Helper()
+
+	var count int
+	if err := dataBase.QueryRow(`SELECT count(*) FROM sessions WHERE id = $1;`, userId).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestChangePassword(t *testing.T) {
+	requireDatabase(t)
+	defer func(m Mailer) { mailer = m }(mailer)
+	m := &memoryMailer{}
+	mailer = m
+	router := accountRouter()
+
+	userId := createTestUser(t, "account-test-password@example.com")
+	numericId, _ := strconv.Atoi(userId)
+	_, err := createSession(numericId, "phone", "10.0.0.1")
+	assert.NoError(t, err)
+
+	w := performRequest(router, http.MethodPatch, "/password", changePasswordRequest{Id: userId, Pwd: "password"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(router, http.MethodPatch, "/password", changePasswordRequest{Id: userId, Pwd: "wrong", NewPwd: "new password"})
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	assert.Equal(t, 1, countSessions(t, userId))
+
+	w = performRequest(router, http.MethodPatch, "/password", changePasswordRequest{Id: userId, Pwd: "password", NewPwd: "new password"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// only the new password works, and every session is signed out
+	_, ok, err := checkPassword(userId, "password")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, _ = checkPassword(userId, "new password")
+	assert.True(t, ok)
+	assert.Equal(t, 0, countSessions(t, userId))
+
+	mail, sent := m.last("account-test-password@example.com")
+	assert.True(t, sent)
+	assert.Equal(t, "Your password was changed", mail.Subject)
+}
+
+func TestChangeEmail(t *testing.T) {
+	requireDatabase(t)
+	defer func(m Mailer) { mailer = m }(mailer)
+	m := &memoryMailer{}
+	mailer = m
+	router := accountRouter()
+
+	userId := createTestUser(t, "account-test-email@example.com")
+	createTestUser(t, "account-test-taken@example.com")
+
+	w := performRequest(router, http.MethodPatch, "/email", changeEmailRequest{Id: userId, Pwd: "password", Email: "not an email"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(router, http.MethodPatch, "/email", changeEmailRequest{Id: userId, Pwd: "wrong", Email: "account-test-new@example.com"})
+	assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	w = performRequest(router, http.MethodPatch, "/email", changeEmailRequest{Id: userId, Pwd: "password", Email: "account-test-taken@example.com"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = performRequest(router, http.MethodPatch, "/email", changeEmailRequest{Id: userId, Pwd: "password", Email: "account-test-new@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the account keeps its address until the new one is verified, the old one is told
+	email, verified := authEmail(t, userId)
+	assert.Equal(t, "account-test-email@example.com", email)
+	assert.True(t, verified)
+	mail, sent := m.last("account-test-email@example.com")
+	assert.True(t, sent)
+	assert.Equal(t, "Your email is being changed", mail.Subject)
+
+	router.POST("/verifyEmail", verifyEmail)
+	w = performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{mailedToken(t, m, "account-test-new@example.com")})
+	assert.Equal(t, http.StatusOK, w.Code)
+	email, _ = authEmail(t, userId)
+	assert.Equal(t, "account-test-new@example.com", email)
+}
+
+func TestConfirmPasswordThrottled(t *testing.T) {
+	requireDatabase(t)
+	defer func(th throttle) { loginThrottle = th }(loginThrottle)
+	loginThrottle.store = newMemoryAttemptStore()
+	loginThrottle.base = time.Minute
+	router := accountRouter()
+
+	userId := createTestUser(t, "account-test-throttle@example.com")
+	for i := 0; i <= loginThrottle.free; i++ {
+		w := performRequest(router, http.MethodPatch, "/password", changePasswordRequest{Id: userId, Pwd: "wrong", NewPwd: "new password"})
+		assert.Equal(t, http.StatusExpectationFailed, w.Code)
+	}
+
+	// guessing the password here locks out logins too
+	w := performRequest(router, http.MethodPatch, "/password", changePasswordRequest{Id: userId, Pwd: "password", NewPwd: "new password"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	wait, _ := loginThrottle.wait(time.Now(), accountKey("account-test-throttle@example.com"))
+	assert.True(t, wait > 0)
+
+	_, ok, _ := checkPassword(userId, "password")
+	assert.True(t, ok)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type adminRequest struct {
+	Id      string `json:"id"`
+	AdminId string `json:"admin_id"`
+}
+
+func isAdmin(userId string) (bool, error) {
+	var ret bool
+	err := dataBase.QueryRow(`SELECT is_admin FROM auth WHERE id = $1;`, userId).Scan(&ret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return ret, err
+}
+
+// permanently delete an event, only admins may do this
+func adminRemoveEvent(context *gin.Context) {
+	var request adminRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	admin, err := isAdmin(request.AdminId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	if !admin {
+		context.IndentedJSON(http.StatusForbidden, newResponse("admin only", []interface{}{request}))
+		return
+	}
+
+	// keep a tombstone so calendar feeds can cancel the entry
+	statement := `
+		WITH removed AS (
+			DELETE from events WHERE event_id = $1
+			RETURNING event_id, user_id, name, datetime, rrule, sequence
+		)
+		INSERT INTO removed_events (event_id, user_id, name, datetime, rrule, sequence, removed_at)
+		SELECT event_id, user_id, name, datetime, rrule, sequence + 1, current_timestamp FROM removed;
+	`
+	_, err = dataBase.Exec(statement, request.Id)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+}
+
+type moderationItem struct {
+	ItemId    int       `json:"item_id"`
+	Kind      string    `json:"kind"`
+	RefId     string    `json:"ref_id"`
+	UserId    string    `json:"user_id"`
+	Content   string    `json:"content"`
+	Rules     []string  `json:"rules"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// content flagged by the content filter that nobody has reviewed yet, oldest first
+func getModerationQueue(context *gin.Context) {
+	var request adminRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	admin, err := isAdmin(request.AdminId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	if !admin {
+		context.IndentedJSON(http.StatusForbidden, newResponse("admin only", []interface{}{request}))
+		return
+	}
+
+	rows, err := dataBase.Query(`
+		SELECT item_id, kind, ref_id, user_id, content, rules, created_at
+		FROM moderation_queue
+		WHERE reviewed_at IS NULL
+		ORDER BY created_at
+		LIMIT 50;
+	`)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	defer rows.Close()
+
+	ret := []moderationItem{}
+	for rows.Next() {
+		temp := new(moderationItem)
+		rows.Scan(&temp.ItemId, &temp.Kind, &temp.RefId, &temp.UserId, &temp.Content, pq.Array(&temp.Rules), &temp.CreatedAt)
+		ret = append(ret, *temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
+
+// mark an item of the moderation queue as reviewed
+func reviewModerationItem(context *gin.Context) {
+	var request adminRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	admin, err := isAdmin(request.AdminId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	if !admin {
+		context.IndentedJSON(http.StatusForbidden, newResponse("admin only", []interface{}{request}))
+		return
+	}
+
+	_, err = dataBase.Exec(`UPDATE moderation_queue SET reviewed_at = current_timestamp WHERE item_id = $1;`, request.Id)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+}
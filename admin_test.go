@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestEvent(t *testing.T, owner string, members string) string {
+	t.Helper()
+
+	var eventId string
+	err := dataBase.QueryRow(`
+		INSERT INTO events (user_id, size, name, description, datetime, owner, rrule)
+		VALUES ($1::bigint[], 10, 'Test event', '', $2, $3, '')
+		RETURNING event_id;
+	`, members, time.Now().Add(24*time.Hour), owner).Scan(&eventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dataBase.Exec(`DELETE FROM events WHERE event_id = $1;`, eventId)
+		dataBase.Exec(`DELETE FROM removed_events WHERE event_id = $1;`, eventId)
+	})
+	return eventId
+}
+
+func TestAdminRemoveEvent(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/admin/event", adminRemoveEvent)
+
+	owner := createTestUser(t, "admin-test-owner@example.com")
+	admin := createTestUser(t, "admin-test-admin@example.com")
+	_, err := dataBase.Exec(`UPDATE auth SET is_admin = true WHERE id = $1;`, admin)
+	assert.NoError(t, err)
+	eventId := createTestEvent(t, owner, "{"+owner+"}")
+
+	// only admins may delete events, owners included
+	w := performRequest(router, http.MethodDelete, "/admin/event", adminRequest{Id: eventId, AdminId: owner})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = performRequest(router, http.MethodDelete, "/admin/event", adminRequest{Id: eventId, AdminId: admin})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var events, tombstones int
+	dataBase.QueryRow(`SELECT count(*) FROM events WHERE event_id = $1;`, eventId).Scan(&events)
+	dataBase.QueryRow(`SELECT count(*) FROM removed_events WHERE event_id = $1;`, eventId).Scan(&tombstones)
+	assert.Equal(t, 0, events)
+	assert.Equal(t, 1, tombstones)
+}
+
+func TestRemoveEventCancels(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/removeEvent", requireSession(), removeEvent)
+
+	owner := createTestUser(t, "cancel-test-owner@example.com")
+	member := createTestUser(t, "cancel-test-member@example.com")
+	eventId := createTestEvent(t, owner, "{"+owner+","+member+"}")
+
+	w := performRequestAs(t, router, http.MethodDelete, "/removeEvent", owner, cancelEventRequest{Id: eventId, Reason: "rain"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// kept for history, out of the listing
+	var status, reason string
+	err := dataBase.QueryRow(`SELECT status, cancel_reason FROM events WHERE event_id = $1;`, eventId).Scan(&status, &reason)
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", status)
+	assert.Equal(t, "rain", reason)
+
+	events, err := upcomingEvents(func(e event) bool { return e.EventId == eventId })
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	// every participant is told
+	for _, userId := range []string{owner, member} {
+		var msg string
+		err = dataBase.QueryRow(`SELECT msg FROM notifications WHERE id = $1 AND kind = 'event_cancelled';`, userId).Scan(&msg)
+		assert.NoError(t, err)
+		assert.Equal(t, "Test event has been cancelled: rain", msg)
+	}
+
+	// cancelling twice is not found
+	w = performRequestAs(t, router, http.MethodDelete, "/removeEvent", owner, cancelEventRequest{Id: eventId})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRemoveEventOwner(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/removeEvent", requireSession(), removeEvent)
+
+	owner := createTestUser(t, "cancel-owner-test-owner@example.com")
+	member := createTestUser(t, "cancel-owner-test-member@example.com")
+	admin := createTestUser(t, "cancel-owner-test-admin@example.com")
+	_, err := dataBase.Exec(`UPDATE auth SET is_admin = true WHERE id = $1;`, admin)
+	assert.NoError(t, err)
+	eventId := createTestEvent(t, owner, "{"+owner+","+member+"}")
+
+	// participants may not cancel, and nobody is told
+	w := performRequestAs(t, router, http.MethodDelete, "/removeEvent", member, cancelEventRequest{Id: eventId})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var status string
+	dataBase.QueryRow(`SELECT status FROM events WHERE event_id = $1;`, eventId).Scan(&status)
+	assert.Equal(t, "active", status)
+	var notified int
+	dataBase.QueryRow(`SELECT count(*) FROM notifications WHERE id = $1 AND kind = 'event_cancelled';`, owner).Scan(&notified)
+	assert.Equal(t, 0, notified)
+
+	w = performRequestAs(t, router, http.MethodDelete, "/removeEvent", admin, cancelEventRequest{Id: eventId})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestEventOwner(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/setEvent", requireSession(), setEvent)
+	router.PATCH("/editEvent", requireSession(), editEvent)
+
+	owner := createTestUser(t, "owner-test-owner@example.com")
+	member := createTestUser(t, "owner-test-member@example.com")
+	ownerId, _ := strconv.ParseInt(owner, 10, 64)
+
+	// the creator owns a new event whoever the body lists first
+	w := performRequestAs(t, router, http.MethodPost, "/setEvent", member, event{
+		UserIds:  []int64{ownerId},
+		Size:     4,
+		Name:     "Picnic",
+		DateTime: time.Now().Add(24 * time.Hour),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	t.Cleanup(func() { dataBase.Exec(`DELETE FROM events WHERE owner = $1;`, member) })
+	var created int
+	dataBase.QueryRow(`SELECT count(*) FROM events WHERE owner = $1;`, owner).Scan(&created)
+	assert.Equal(t, 0, created)
+
+	eventId := createTestEvent(t, owner, "{"+owner+","+member+"}")
+	var start time.Time
+	err := dataBase.QueryRow(`UPDATE events SET rrule = 'FREQ=WEEKLY' WHERE event_id = $1 RETURNING datetime;`, eventId).Scan(&start)
+	assert.NoError(t, err)
+
+	// participants may not edit the series or one of its occurrences
+	request := editEventRequest{}
+	request.EventId = eventId
+	request.Name = "Taken over"
+	request.Size = 10
+	request.DateTime = start
+	w = performRequestAs(t, router, http.MethodPatch, "/editEvent", member, request)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	occurrence := start.AddDate(0, 0, 7)
+	request.Scope = "occurrence"
+	request.Occurrence = &occurrence
+	request.Cancelled = true
+	w = performRequestAs(t, router, http.MethodPatch, "/editEvent", member, request)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var name string
+	var overrides int
+	dataBase.QueryRow(`SELECT name FROM events WHERE event_id = $1;`, eventId).Scan(&name)
+	dataBase.QueryRow(`SELECT count(*) FROM event_occurrences WHERE event_id = $1;`, eventId).Scan(&overrides)
+	assert.Equal(t, "Test event", name)
+	assert.Equal(t, 0, overrides)
+
+	w = performRequestAs(t, router, http.MethodPatch, "/editEvent", owner, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
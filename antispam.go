@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many messages a user may send within messageRateWindow
+const messageRateLimit = 30
+
+const messageRateWindow = time.Minute
+
+// the same text sent to the same user again within this window is refused
+const duplicateMessageWindow = 30 * time.Second
+
+// a message that was refused, sent back to the client as the response body
+type sendError struct {
+	status     int
+	msg        string
+	Code       string `json:"code"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// sliding window counter per key
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+var messageLimiter = newRateLimiter(messageRateLimit, messageRateWindow)
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// record a hit for key at now, returns how long to wait when the limit is already reached
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// keys nobody used for a window are forgotten, so the map does not grow with every sender
+	if now.Sub(l.lastSweep) > l.window {
+		l.sweep(now)
+	}
+
+	hits := l.recent(key, now)
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// the hits of key still in the window at now
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	hits := l.hits[key]
+	i := 0
+	for i < len(hits) && !hits[i].After(now.Add(-l.window)) {
+		i++
+	}
+	return hits[i:]
+}
+
+// drop every key without hits in the window
+func (l *rateLimiter) sweep(now time.Time) {
+	for key := range l.hits {
+		if len(l.recent(key, now)) == 0 {
+			delete(l.hits, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// whether both users take part in the same event, or occurrence of one, that is not cancelled
+func shareEvent(a string, b string) (bool, error) {
+	var count int
+	err := dataBase.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT user_id FROM events WHERE status = 'active'
+			UNION ALL
+			SELECT event_occurrences.user_id
+			FROM event_occurrences
+			JOIN events ON events.event_id = event_occurrences.event_id
+			WHERE events.status = 'active' AND NOT event_occurrences.cancelled
+		) AS participants
+		WHERE $1 = ANY(user_id) AND $2 = ANY(user_id);
+	`, a, b).Scan(&count)
+	return count > 0, err
+}
+
+// refuse messages from unverified users, to users who are neither matched nor at the same event, floods and repeats
+func checkSend(m message) (*sendError, error) {
+	verified, err := isVerified(m.IdFrom)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return &sendError{status: http.StatusForbidden, msg: "email not verified", Code: "unverified"}, nil
+	}
+
+	matched, err := isMutualMatch(m.IdFrom, m.IdTo)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		shared, err := shareEvent(m.IdFrom, m.IdTo)
+		if err != nil {
+			return nil, err
+		}
+		if !shared {
+			return &sendError{status: http.StatusForbidden, msg: "not matched", Code: "not_matched"}, nil
+		}
+	}
+
+	return checkFlood(m)
+}
+
+// refuse text just sent to the same user, and senders over the rate limit. edits count as messages.
+func checkFlood(m message) (*sendError, error) {
+	if m.Msg != "" {
+		var count int
+		err := dataBase.QueryRow(`
+			SELECT COUNT(*)
+			FROM messaage
+			WHERE id_from = $1 AND id_to = $2 AND msg = $3 AND time_sent > $4;
+		`, m.IdFrom, m.IdTo, m.Msg, time.Now().Add(-duplicateMessageWindow)).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return &sendError{status: http.StatusConflict, msg: "duplicate message", Code: "duplicate"}, nil
+		}
+	}
+
+	// counted last, so refused messages do not use up the limit
+	if ok, wait := messageLimiter.allow(m.IdFrom, time.Now()); !ok {
+		seconds := int(math.Ceil(wait.Seconds()))
+		return &sendError{status: http.StatusTooManyRequests, msg: "too many messages", Code: "rate_limited", RetryAfter: seconds}, nil
+	}
+	return nil, nil
+}
+
+// answer a refused message with its status and code
+func respondRefused(context *gin.Context, refused *sendError) {
+	if refused.RetryAfter > 0 {
+		context.Header("Retry-After", strconv.Itoa(refused.RetryAfter))
+	}
+	context.IndentedJSON(refused.status, newResponse(refused.msg, []interface{}{refused}))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	ok, _ := l.allow("1", now)
+	assert.True(t, ok)
+	ok, _ = l.allow("1", now.Add(10*time.Second))
+	assert.True(t, ok)
+
+	ok, wait := l.allow("1", now.Add(20*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 40*time.Second, wait)
+
+	// other users have their own window
+	ok, _ = l.allow("2", now.Add(20*time.Second))
+	assert.True(t, ok)
+
+	// the first hit has left the window
+	ok, _ = l.allow("1", now.Add(61*time.Second))
+	assert.True(t, ok)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Now()
+
+	l.allow("1", now)
+	l.allow("2", now.Add(30*time.Second))
+	assert.Len(t, l.hits, 2)
+
+	// the next hit after a window sweeps the keys that went quiet
+	l.allow("3", now.Add(61*time.Second))
+	assert.Len(t, l.hits, 2)
+	assert.NotContains(t, l.hits, "1")
+
+	l.allow("3", now.Add(200*time.Second))
+	assert.Equal(t, []string{"3"}, limiterKeys(l.hits))
+}
+
+func limiterKeys(m map[string][]time.Time) []string {
+	ret := []string{}
+	for key := range m {
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+func TestCheckSendDuplicate(t *testing.T) {
+	requireDatabase(t)
+	defer func(l *rateLimiter) { messageLimiter = l }(messageLimiter)
+	messageLimiter = newRateLimiter(1, time.Minute)
+
+	sender := createTestProfile(t, "antispam-test-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "antispam-test-recipient@example.com", "Recipient")
+	matchTestUsers(t, sender, recipient)
+	insertTestMessage(t, sender, recipient, "hi")
+
+	refused, err := checkSend(message{IdFrom: sender, IdTo: recipient, Msg: "hi"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, refused) {
+		assert.Equal(t, "duplicate", refused.Code)
+	}
+
+	// the refused repeat did not count against the limit
+	refused, err = checkSend(message{IdFrom: sender, IdTo: recipient, Msg: "hello"})
+	assert.NoError(t, err)
+	assert.Nil(t, refused)
+
+	refused, err = checkSend(message{IdFrom: sender, IdTo: recipient, Msg: "anyone?"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, refused) {
+		assert.Equal(t, "rate_limited", refused.Code)
+	}
+}
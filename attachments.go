@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// largest file accepted as an attachment
+const maxAttachmentSize = 10 << 20
+
+// content types accepted as attachments, detected from the file itself
+var attachmentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type attachment struct {
+	AttachmentId int       `json:"attachment_id"`
+	Owner        string    `json:"owner"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+	Url          string    `json:"url"`
+}
+
+const attachmentColumns = `attachment_id, owner, content_type, size, created_at`
+
+func scanAttachment(row scanner, a *attachment) error {
+	err := row.Scan(&a.AttachmentId, &a.Owner, &a.ContentType, &a.Size, &a.CreatedAt)
+	a.Url = "/attachments/" + strconv.Itoa(a.AttachmentId)
+	return err
+}
+
+// work out the content type from the first bytes of the file, returns the reader with those bytes put back
+func sniffAttachment(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	head = head[:n]
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+func generateBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// upload an image as multipart form data, it can then be sent with sendMessage
+func uploadAttachment(context *gin.Context) {
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, maxAttachmentSize+1<<20)
+
+	owner := currentSession(context).Id
+	header, err := context.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			context.IndentedJSON(http.StatusRequestEntityTooLarge, newResponse("file too large", []interface{}{}))
+			return
+		}
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	if header.Size > maxAttachmentSize {
+		context.IndentedJSON(http.StatusRequestEntityTooLarge, newResponse("file too large", []interface{}{header.Size}))
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	defer file.Close()
+
+	contentType, reader, err := sniffAttachment(file)
+	if err != nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	if !attachmentTypes[contentType] {
+		context.IndentedJSON(http.StatusUnsupportedMediaType, newResponse("unsupported file type", []interface{}{contentType}))
+		return
+	}
+
+	key, err := generateBlobKey()
+	if err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	if err = blobs.Put(key, reader); err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	var temp attachment
+	err = scanAttachment(dataBase.QueryRow(`
+		INSERT INTO attachments (owner, content_type, size, blob_key, created_at)
+		VALUES ($1, $2, $3, $4, current_timestamp)
+		RETURNING `+attachmentColumns+`;
+	`, owner, contentType, header.Size, key), &temp)
+
+	if err != nil {
+		blobs.Delete(key)
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusCreated, newResponse("ok", []interface{}{temp}))
+}
+
+// download an attachment, only its uploader and the other side of its message may
+func getAttachment(context *gin.Context) {
+	var contentType, key string
+	err := dataBase.QueryRow(`
+		SELECT attachments.content_type, attachments.blob_key
+		FROM attachments
+		LEFT JOIN messaage ON messaage.msg_id = attachments.msg_id
+		WHERE attachments.attachment_id = $1
+		AND (attachments.owner = $2 OR messaage.id_to = $2);
+	`, context.Param("id"), currentSession(context).Id).Scan(&contentType, &key)
+
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("attachment not found", []interface{}{context.Param("id")}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{context.Param("id")}))
+		return
+	}
+
+	blob, err := blobs.Get(key)
+	if err != nil {
+		context.IndentedJSON(http.StatusNotFound, newResponse(err.Error(), []interface{}{context.Param("id")}))
+		return
+	}
+	defer blob.Close()
+
+	context.Header("Cache-Control", "private, max-age=86400")
+	context.DataFromReader(http.StatusOK, -1, contentType, blob, nil)
+}
+
+var errAttachmentUnavailable = errors.New("attachment not found or already sent")
+
+// attach uploaded files to a message the owner is sending, in the transaction inserting the message.
+// fails with errAttachmentUnavailable when one of them is not the owner's or was sent before.
+func attachToMessage(tx *sql.Tx, m *message, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	wanted := make(map[int]bool, len(ids))
+	for _, attachmentId := range ids {
+		wanted[attachmentId] = true
+	}
+
+	rows, err := tx.Query(`
+		UPDATE attachments SET msg_id = $1
+		WHERE attachment_id = ANY($2) AND owner = $3 AND msg_id IS NULL
+		RETURNING `+attachmentColumns+`;
+	`, m.MsgId, pq.Array(ids), m.IdFrom)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var temp attachment
+		if err = scanAttachment(rows, &temp); err != nil {
+			return err
+		}
+		m.Attachments = append(m.Attachments, temp)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(m.Attachments) < len(wanted) {
+		return errAttachmentUnavailable
+	}
+	return nil
+}
+
+// fill in the attachments of a page of messages
+func loadAttachments(msgs []message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(msgs))
+	ids := make([]int, len(msgs))
+	for i, m := range msgs {
+		index[m.MsgId] = i
+		ids[i] = m.MsgId
+	}
+
+	rows, err := dataBase.Query(`
+		SELECT msg_id, `+attachmentColumns+`
+		FROM attachments
+		WHERE msg_id = ANY($1)
+		ORDER BY attachment_id;
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msgId int
+		var temp attachment
+		err = rows.Scan(&msgId, &temp.AttachmentId, &temp.Owner, &temp.ContentType, &temp.Size, &temp.CreatedAt)
+		if err != nil {
+			return err
+		}
+		temp.Url = "/attachments/" + strconv.Itoa(temp.AttachmentId)
+		i := index[msgId]
+		msgs[i].Attachments = append(msgs[i].Attachments, temp)
+	}
+	return rows.Err()
+}
+
+// remove the attachments of a deleted message, files go once the rows are gone
+func deleteAttachments(tx *sql.Tx, msgId int) ([]string, error) {
+	rows, err := tx.Query(`DELETE FROM attachments WHERE msg_id = $1 RETURNING blob_key;`, msgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// delete attachments uploaded more than ATTACHMENT_RETENTION ago that were never sent
+func purgeUnsentAttachments(ctx context.Context) error {
+	cutoff := time.Now().Add(-envDuration("ATTACHMENT_RETENTION", 24*time.Hour))
+
+	rows, err := dataBase.QueryContext(ctx, `
+		DELETE FROM attachments WHERE msg_id IS NULL AND created_at < $1
+		RETURNING blob_key;
+	`, cutoff)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	deleteBlobs(keys)
+	return nil
+}
+
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(key); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store := localBlobStore{filepath.Join(t.TempDir(), "blobs")}
+
+	assert.NoError(t, store.Put("abc", bytes.NewReader([]byte("hello"))))
+
+	blob, err := store.Get("abc")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	assert.Equal(t, "hello", string(data))
+
+	assert.NoError(t, store.Delete("abc"))
+	_, err = store.Get("abc")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, store.Delete("abc"))
+
+	assert.Error(t, store.Put("../escape", bytes.NewReader(nil)))
+	assert.Error(t, store.Put("", bytes.NewReader(nil)))
+}
+
+func TestSniffAttachment(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n" + "rest of the image")
+
+	contentType, r, err := sniffAttachment(bytes.NewReader(png))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.True(t, attachmentTypes[contentType])
+
+	// the sniffed bytes are not lost
+	data, _ := io.ReadAll(r)
+	assert.Equal(t, png, data)
+
+	contentType, _, err = sniffAttachment(bytes.NewReader([]byte("just some text")))
+	assert.NoError(t, err)
+	assert.False(t, attachmentTypes[contentType])
+}
+
+func insertTestAttachment(t *testing.T, owner string, key string) int {
+	t.Helper()
+
+	assert.NoError(t, blobs.Put(key, bytes.NewReader([]byte("image"))))
+	var attachmentId int
+	err := dataBase.QueryRow(`
+		INSERT INTO attachments (owner, content_type, size, blob_key, created_at)
+		VALUES ($1, 'image/png', 5, $2, current_timestamp)
+		RETURNING attachment_id;
+	`, owner, key).Scan(&attachmentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attachmentId
+}
+
+func TestAttachToMessage(t *testing.T) {
+	requireDatabase(t)
+	defer func(store blobStore) { blobs = store }(blobs)
+	blobs = localBlobStore{t.TempDir()}
+
+	sender := createTestProfile(t, "attachments-test-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "attachments-test-recipient@example.com", "Recipient")
+	own := insertTestAttachment(t, sender, "attachments-test-own")
+	other := insertTestAttachment(t, recipient, "attachments-test-other")
+
+	tx, err := dataBase.Begin()
+	assert.NoError(t, err)
+	var m message
+	assert.NoError(t, scanMessage(tx.QueryRow(`
+		INSERT INTO messaage (id_from, id_to, msg, time_sent)
+		VALUES ($1, $2, 'look', current_timestamp)
+		RETURNING `+messageColumns+`;
+	`, sender, recipient), &m))
+
+	// only the sender's own uploads can be attached, another's fails the whole message
+	_, err = tx.Exec(`SAVEPOINT attach;`)
+	assert.NoError(t, err)
+	assert.Equal(t, errAttachmentUnavailable, attachToMessage(tx, &m, []int{own, other}))
+	_, err = tx.Exec(`ROLLBACK TO SAVEPOINT attach;`)
+	assert.NoError(t, err)
+
+	m.Attachments = nil
+	assert.NoError(t, attachToMessage(tx, &m, []int{own, own}))
+	if assert.Len(t, m.Attachments, 1) {
+		assert.Equal(t, own, m.Attachments[0].AttachmentId)
+	}
+
+	// and an upload is only sent once
+	m.Attachments = nil
+	assert.Equal(t, errAttachmentUnavailable, attachToMessage(tx, &m, []int{own}))
+
+	// rolled back with the message
+	assert.NoError(t, tx.Rollback())
+	var msgId *int
+	dataBase.QueryRow(`SELECT msg_id FROM attachments WHERE attachment_id = $1;`, own).Scan(&msgId)
+	assert.Nil(t, msgId)
+}
+
+func TestPurgeUnsentAttachments(t *testing.T) {
+	requireDatabase(t)
+	defer func(store blobStore) { blobs = store }(blobs)
+	blobs = localBlobStore{t.TempDir()}
+
+	owner := createTestProfile(t, "attachments-test-purge@example.com", "Owner")
+	recipient := createTestProfile(t, "attachments-test-purge-recipient@example.com", "Recipient")
+	unsent := insertTestAttachment(t, owner, "attachments-test-unsent")
+	recent := insertTestAttachment(t, owner, "attachments-test-recent")
+	sent := insertTestAttachment(t, owner, "attachments-test-sent")
+	msgId := insertTestMessage(t, owner, recipient, "look")
+
+	_, err := dataBase.Exec(`UPDATE attachments SET msg_id = $2 WHERE attachment_id = $1;`, sent, msgId)
+	assert.NoError(t, err)
+	_, err = dataBase.Exec(`
+		UPDATE attachments SET created_at = created_at - interval '2 days' WHERE attachment_id = ANY($1::integer[]);
+	`, pq.Array([]int{unsent, sent}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, purgeUnsentAttachments(context.Background()))
+
+	var left []int
+	rows, err := dataBase.Query(`SELECT attachment_id FROM attachments WHERE owner = $1 ORDER BY attachment_id;`, owner)
+	assert.NoError(t, err)
+	for rows.Next() {
+		var attachmentId int
+		rows.Scan(&attachmentId)
+		left = append(left, attachmentId)
+	}
+	rows.Close()
+	assert.Equal(t, []int{recent, sent}, left)
+
+	_, err = blobs.Get("attachments-test-unsent")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	blob, err := blobs.Get("attachments-test-sent")
+	assert.NoError(t, err)
+	blob.Close()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// a single rule of the content filter, loaded from CONTENT_FILTER_CONFIG
+type filterRule struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Words   []string `json:"words,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Action  string   `json:"action"`
+
+	re *regexp.Regexp
+	// when set, only matches it accepts count
+	valid func(match string) bool
+}
+
+// rules applied in order to user written text
+type contentFilter struct {
+	rules []filterRule
+}
+
+type filterResult struct {
+	Text     string
+	Rejected string
+	Flagged  []string
+}
+
+const linkPattern = `(?i)\b(?:https?://|www\.)\S+|\b[a-z0-9-]+\.(?:com|net|org|io|me|co|sg|ly|gg)\b(?:/\S*)?`
+
+// runs of at least 9 digits with up to two separators between them, isPhoneNumber has the final say
+const phonePattern = `\+?\d(?:[\s().-]{0,2}\d){8,}`
+
+// dates written year first, day first or month first
+var datePattern = regexp.MustCompile(`\b(?:` +
+	`(?:19|20)\d\d[.-](?:0?[1-9]|1[0-2])[.-](?:0?[1-9]|[12]\d|3[01])|` +
+	`(?:0?[1-9]|[12]\d|3[01])[.-](?:0?[1-9]|1[0-2])[.-](?:19|20)\d\d|` +
+	`(?:0?[1-9]|1[0-2])[.-](?:0?[1-9]|[12]\d|3[01])[.-](?:19|20)\d\d` +
+	`)\b`)
+
+// phone numbers have 9 to 15 digits, longer runs are ids or card numbers, and do not contain dates
+func isPhoneNumber(match string) bool {
+	digits := 0
+	for _, r := range match {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15 && !datePattern.MatchString(match)
+}
+
+// used when no configuration file is given
+var defaultFilterRules = []filterRule{
+	{Name: "link", Type: "link", Action: "flag"},
+	{Name: "phone", Type: "phone", Action: "mask"},
+}
+
+var contentFilters *contentFilter
+
+func init() {
+	rules := defaultFilterRules
+
+	if path := os.Getenv("CONTENT_FILTER_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = json.Unmarshal(data, &rules); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	filter, err := newContentFilter(rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	contentFilters = filter
+}
+
+func newContentFilter(rules []filterRule) (*contentFilter, error) {
+	filter := new(contentFilter)
+
+	for _, rule := range rules {
+		var pattern string
+		switch rule.Type {
+		case "words":
+			quoted := make([]string, len(rule.Words))
+			for i, w := range rule.Words {
+				quoted[i] = regexp.QuoteMeta(w)
+			}
+			pattern = `(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`
+		case "regex":
+			pattern = rule.Pattern
+		case "link":
+			pattern = linkPattern
+		case "phone":
+			pattern = phonePattern
+			rule.valid = isPhoneNumber
+		default:
+			return nil, fmt.Errorf("filter rule %q: unknown type %q", rule.Name, rule.Type)
+		}
+
+		if rule.Action != "reject" && rule.Action != "mask" && rule.Action != "flag" {
+			return nil, fmt.Errorf("filter rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("filter rule %q: %s", rule.Name, err)
+		}
+		rule.re = re
+		filter.rules = append(filter.rules, rule)
+	}
+	return filter, nil
+}
+
+// where the rule matches text
+func (rule filterRule) find(text string) [][]int {
+	var ret [][]int
+	for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+		if rule.valid == nil || rule.valid(text[loc[0]:loc[1]]) {
+			ret = append(ret, loc)
+		}
+	}
+	return ret
+}
+
+// run text through every rule, masking replaces matches with asterisks for the rules after it
+func (f *contentFilter) check(text string) filterResult {
+	ret := filterResult{Text: text}
+
+	for _, rule := range f.rules {
+		matches := rule.find(ret.Text)
+		if len(matches) == 0 {
+			continue
+		}
+
+		switch rule.Action {
+		case "reject":
+			ret.Rejected = rule.Name
+			return ret
+		case "mask":
+			var masked strings.Builder
+			last := 0
+			for _, loc := range matches {
+				masked.WriteString(ret.Text[last:loc[0]])
+				masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(ret.Text[loc[0]:loc[1]])))
+				last = loc[1]
+			}
+			masked.WriteString(ret.Text[last:])
+			ret.Text = masked.String()
+		case "flag":
+			ret.Flagged = append(ret.Flagged, rule.Name)
+		}
+	}
+	return ret
+}
+
+// filter text in place, responds and returns false when it is rejected, otherwise returns the rules it was flagged by
+func filterContent(context *gin.Context, text *string, body interface{}) ([]string, bool) {
+	result := contentFilters.check(*text)
+
+	if result.Rejected != "" {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("content rejected: "+result.Rejected, []interface{}{body}))
+		return nil, false
+	}
+
+	*text = result.Text
+	return result.Flagged, true
+}
+
+// put flagged content in the moderation queue
+func flagForReview(kind string, refId string, userId string, content string, rules []string) {
+	if len(rules) == 0 {
+		return
+	}
+
+	_, err := dataBase.Exec(`
+		INSERT INTO moderation_queue (kind, ref_id, user_id, content, rules, created_at)
+		VALUES ($1, $2, $3, $4, $5, current_timestamp);
+	`, kind, refId, userId, content, pq.Array(rules))
+	if err != nil {
+		log.Println(err)
+	}
+}
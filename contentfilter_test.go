@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentFilter(t *testing.T) {
+	filter, err := newContentFilter([]filterRule{
+		{Name: "slurs", Type: "words", Words: []string{"badword", "worse word"}, Action: "reject"},
+		{Name: "mild", Type: "words", Words: []string{"darn"}, Action: "mask"},
+		{Name: "crypto", Type: "regex", Pattern: `(?i)bitcoin`, Action: "flag"},
+		{Name: "link", Type: "link", Action: "flag"},
+		{Name: "phone", Type: "phone", Action: "mask"},
+	})
+	assert.NoError(t, err)
+
+	result := filter.check("hello there")
+	assert.Equal(t, filterResult{Text: "hello there"}, result)
+
+	result = filter.check("what a BadWord")
+	assert.Equal(t, "slurs", result.Rejected)
+
+	// whole words only
+	result = filter.check("badwords are fine")
+	assert.Empty(t, result.Rejected)
+
+	result = filter.check("darn, call me on +65 9123 4567")
+	assert.Equal(t, "****, call me on *************", result.Text)
+	assert.Empty(t, result.Flagged)
+
+	result = filter.check("buy bitcoin at www.example.com/now or scam.io")
+	assert.Equal(t, []string{"crypto", "link"}, result.Flagged)
+	assert.Equal(t, "buy bitcoin at www.example.com/now or scam.io", result.Text)
+}
+
+func TestContentFilterPhone(t *testing.T) {
+	filter, err := newContentFilter(defaultFilterRules)
+	assert.NoError(t, err)
+
+	masked := map[string]string{
+		"call +65 9123 4567":   "call *************",
+		"(555) 123-4567 ok?":   "(************* ok?",
+		"555.123.4567":         "************",
+		"0412 345 678 or 0412": "************ or 0412",
+	}
+	for text, want := range masked {
+		assert.Equal(t, want, filter.check(text).Text, text)
+	}
+
+	// dates, short numbers and long ids are left alone
+	for _, text := range []string{
+		"see you on 2024-01-15",
+		"from 15.01.2024 to 20.01.2024",
+		"2024-01-15 1030 at the station",
+		"12-31-2024 1200",
+		"my order 12345678 arrived",
+		"card ending 1234567890123456",
+		"I ran 5 km in 25 minutes",
+		"score 3-2",
+	} {
+		assert.Equal(t, text, filter.check(text).Text, text)
+	}
+}
+
+func TestContentFilterConfig(t *testing.T) {
+	_, err := newContentFilter([]filterRule{{Name: "x", Type: "unknown", Action: "flag"}})
+	assert.Error(t, err)
+
+	_, err = newContentFilter([]filterRule{{Name: "x", Type: "link", Action: "delete"}})
+	assert.Error(t, err)
+
+	_, err = newContentFilter([]filterRule{{Name: "x", Type: "regex", Pattern: "(", Action: "flag"}})
+	assert.Error(t, err)
+
+	_, err = newContentFilter(defaultFilterRules)
+	assert.NoError(t, err)
+}
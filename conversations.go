@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type conversation struct {
+	Peer        profile  `json:"peer"`
+	LastMessage *message `json:"last_message"`
+	Unread      int      `json:"unread"`
+}
+
+type readCursor struct {
+	Id     string `json:"id"`
+	PeerId string `json:"peer_id"`
+	MsgId  int    `json:"msg_id"`
+}
+
+// whether both users are interested in each other, the condition getChat uses
+func isMutualMatch(a string, b string) (bool, error) {
+	var count int
+	err := dataBase.QueryRow(`
+		SELECT COUNT(*)
+		FROM interested
+		WHERE ((id_from = $1 AND id_to = $2) OR (id_from = $2 AND id_to = $1))
+		AND id_from != id_to;
+	`, a, b).Scan(&count)
+	return count == 2, err
+}
+
+// ids of every mutual match of a user
+func mutualMatches(userId string) ([]string, error) {
+	rows, err := dataBase.Query(`
+		SELECT DISTINCT outer_interest.id_to
+		FROM interested AS outer_interest
+		JOIN interested AS inner_interest
+		ON inner_interest.id_from = outer_interest.id_to AND inner_interest.id_to = outer_interest.id_from
+		WHERE outer_interest.id_from = $1
+		AND outer_interest.id_from != outer_interest.id_to;
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var peer string
+		if err = rows.Scan(&peer); err != nil {
+			return nil, err
+		}
+		ret = append(ret, peer)
+	}
+	return ret, rows.Err()
+}
+
+// move a user's read cursor in a conversation forward, it never moves back
+func updateReadCursor(userId string, peerId string, msgId int) error {
+	statement := `
+		INSERT INTO read_cursors (id, peer_id, last_read_msg_id, updated_at)
+		VALUES ($1, $2, $3, current_timestamp)
+		ON CONFLICT (id, peer_id)
+		DO UPDATE SET last_read_msg_id = GREATEST(read_cursors.last_read_msg_id, $3), updated_at = current_timestamp;
+	`
+	_, err := dataBase.Exec(statement, userId, peerId, msgId)
+	return err
+}
+
+// every mutual match with the latest message and unread count, most recent first
+func getConversation(context *gin.Context) {
+	var newId id
+
+	if err := context.BindJSON(&newId); err != nil {
+		return
+	}
+
+	sqlstatement := `
+		WITH matches AS (
+			SELECT outer_interest.id_to AS peer
+			FROM interested AS outer_interest
+			WHERE outer_interest.id_from = $1
+			AND (
+				SELECT COUNT(*)
+				FROM interested AS inner_interest
+				WHERE inner_interest.id_from = outer_interest.id_to
+				AND inner_interest.id_to = $1
+			) > 0
+			AND outer_interest.id_from != outer_interest.id_to
+		)
+		SELECT profile.id, profile.name, profile.age, profile.bio, profile.pfp,
+			last_msg.msg_id, last_msg.id_from, last_msg.id_to, last_msg.msg, last_msg.time_sent,
+			last_msg.delivered_at, last_msg.read_at, last_msg.edited_at, last_msg.deleted_at,
+			(
+				SELECT COUNT(*)
+				FROM messaage
+				WHERE id_from = matches.peer AND id_to = $1
+				AND msg_id > COALESCE(read_cursors.last_read_msg_id, 0)
+				AND deleted_at IS NULL
+			)
+		FROM matches
+		JOIN profile ON profile.id = matches.peer
+		LEFT JOIN LATERAL (
+			SELECT ` + messageColumns + `
+			FROM messaage
+			WHERE (id_from = $1 AND id_to = matches.peer)
+			OR (id_to = $1 AND id_from = matches.peer)
+			ORDER BY time_sent DESC
+			LIMIT 1
+		) AS last_msg ON true
+		LEFT JOIN read_cursors ON read_cursors.id = $1 AND read_cursors.peer_id = matches.peer
+		ORDER BY last_msg.time_sent DESC NULLS LAST;
+	`
+
+	rows, err := dataBase.Query(sqlstatement, newId.Id)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newId}))
+		return
+	}
+	defer rows.Close()
+
+	ret := []conversation{}
+	for rows.Next() {
+		var temp conversation
+		var msgId sql.NullInt64
+		var idFrom, idTo, msg sql.NullString
+		var timeSent sql.NullTime
+		var deliveredAt, readAt, editedAt, deletedAt *time.Time
+
+		err = rows.Scan(
+			&temp.Peer.ID, &temp.Peer.Name, &temp.Peer.Age, &temp.Peer.Bio, &temp.Peer.Pfp,
+			&msgId, &idFrom, &idTo, &msg, &timeSent,
+			&deliveredAt, &readAt, &editedAt, &deletedAt,
+			&temp.Unread)
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newId}))
+			return
+		}
+
+		if msgId.Valid {
+			temp.LastMessage = &message{
+				MsgId:       int(msgId.Int64),
+				IdFrom:      idFrom.String,
+				IdTo:        idTo.String,
+				Msg:         msg.String,
+				TimeSent:    timeSent.Time,
+				DeliveredAt: deliveredAt,
+				ReadAt:      readAt,
+				EditedAt:    editedAt,
+				DeletedAt:   deletedAt,
+			}
+		}
+		ret = append(ret, temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
+
+// mark a conversation read up to and including msg_id, the peer is told over the real time connection
+func markConversationRead(context *gin.Context) {
+	var cursor readCursor
+
+	if err := context.BindJSON(&cursor); err != nil {
+		return
+	}
+
+	if err := markRead(cursor); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{cursor}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{cursor}))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// a user with a profile, matched with nobody yet
+func createTestProfile(t *testing.T, email string, name string) string {
+	t.Helper()
+
+	userId := createTestUser(t, email)
+	_, err := dataBase.Exec(`INSERT INTO profile (id, name, age, bio, pfp) VALUES ($1, $2, 25, '', '');`, userId, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userId
+}
+
+// make two users interested in each other
+func matchTestUsers(t *testing.T, a string, b string) {
+	t.Helper()
+
+	_, err := dataBase.Exec(`INSERT INTO interested (id_from, id_to) VALUES ($1, $2), ($2, $1);`, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTestMessage(t *testing.T, from string, to string, msg string) int {
+	t.Helper()
+
+	var temp message
+	err := scanMessage(dataBase.QueryRow(`
+		INSERT INTO messaage (id_from, id_to, msg, time_sent)
+		VALUES ($1, $2, $3, clock_timestamp())
+		RETURNING `+messageColumns+`;
+	`, from, to, msg), &temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return temp.MsgId
+}
+
+func getConversations(t *testing.T, router *gin.Engine, userId string) []conversation {
+	t.Helper()
+
+	w := performRequest(router, http.MethodPost, "/getConversation", id{userId})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Body [][]conversation `json:"body"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Body[0]
+}
+
+func TestMutualMatches(t *testing.T) {
+	requireDatabase(t)
+
+	a := createTestProfile(t, "matches-test-a@example.com", "A")
+	b := createTestProfile(t, "matches-test-b@example.com", "B")
+	c := createTestProfile(t, "matches-test-c@example.com", "C")
+	matchTestUsers(t, a, b)
+	// only one way
+	_, err := dataBase.Exec(`INSERT INTO interested (id_from, id_to) VALUES ($1, $2);`, a, c)
+	assert.NoError(t, err)
+
+	matched, err := isMutualMatch(a, b)
+	assert.NoError(t, err)
+	assert.True(t, matched)
+
+	matched, err = isMutualMatch(a, c)
+	assert.NoError(t, err)
+	assert.False(t, matched)
+
+	peers, err := mutualMatches(a)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{b}, peers)
+}
+
+func TestGetConversation(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/getConversation", getConversation)
+	router.POST("/markConversationRead", markConversationRead)
+
+	me := createTestProfile(t, "conversation-test-me@example.com", "Me")
+	quiet := createTestProfile(t, "conversation-test-quiet@example.com", "Quiet")
+	chatty := createTestProfile(t, "conversation-test-chatty@example.com", "Chatty")
+	matchTestUsers(t, me, quiet)
+	matchTestUsers(t, me, chatty)
+
+	first := insertTestMessage(t, chatty, me, "hi")
+	insertTestMessage(t, me, chatty, "hello")
+	last := insertTestMessage(t, chatty, me, "how are you?")
+
+	// conversations with messages first, latest message and unread count for each
+	conversations := getConversations(t, router, me)
+	assert.Len(t, conversations, 2)
+	assert.Equal(t, chatty, conversations[0].Peer.ID)
+	assert.Equal(t, "how are you?", conversations[0].LastMessage.Msg)
+	assert.Equal(t, 2, conversations[0].Unread)
+	assert.Equal(t, quiet, conversations[1].Peer.ID)
+	assert.Nil(t, conversations[1].LastMessage)
+	assert.Equal(t, 0, conversations[1].Unread)
+
+	w := performRequest(router, http.MethodPost, "/markConversationRead", readCursor{Id: me, PeerId: chatty, MsgId: first})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, getConversations(t, router, me)[0].Unread)
+
+	w = performRequest(router, http.MethodPost, "/markConversationRead", readCursor{Id: me, PeerId: chatty, MsgId: last})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, getConversations(t, router, me)[0].Unread)
+
+	// the cursor never moves back
+	w = performRequest(router, http.MethodPost, "/markConversationRead", readCursor{Id: me, PeerId: chatty, MsgId: first})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, getConversations(t, router, me)[0].Unread)
+
+	// an unsent message is not unread
+	unsent := insertTestMessage(t, chatty, me, "oops")
+	assert.Equal(t, 1, getConversations(t, router, me)[0].Unread)
+	_, err := dataBase.Exec(`UPDATE messaage SET msg = '', deleted_at = current_timestamp WHERE msg_id = $1;`, unsent)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, getConversations(t, router, me)[0].Unread)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type eventMessage struct {
+	MsgId    int       `json:"msg_id"`
+	EventId  string    `json:"event_id"`
+	IdFrom   string    `json:"id_from"`
+	Msg      string    `json:"msg"`
+	TimeSent time.Time `json:"time_sent"`
+}
+
+// everyone taking part in the event, or in any occurrence of it
+func eventMembers(eventId string) ([]int64, error) {
+	rows, err := dataBase.Query(`
+		SELECT DISTINCT unnest(user_id) FROM (
+			SELECT user_id FROM events WHERE event_id = $1
+			UNION ALL
+			SELECT user_id FROM event_occurrences WHERE event_id = $1
+		) AS participants;
+	`, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []int64
+	for rows.Next() {
+		var member int64
+		if err = rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func isEventMember(eventId string, userId string) (bool, error) {
+	members, err := eventMembers(eventId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, member := range members {
+		if strconv.FormatInt(member, 10) == userId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 10 messages of an event's group chat sent before time_sent
+func getEventMessage(context *gin.Context) {
+	var request eventMessage
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	member, err := isEventMember(request.EventId, request.IdFrom)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	if !member {
+		context.IndentedJSON(http.StatusForbidden, newResponse("not a participant", []interface{}{request}))
+		return
+	}
+
+	sqlstatement := `
+	SELECT msg_id, event_id, id_from, msg, time_sent FROM event_messages
+	WHERE event_id = $1
+	AND (time_sent < $2)
+	ORDER BY time_sent DESC
+	LIMIT 10;`
+
+	rows, err := dataBase.Query(sqlstatement, request.EventId, request.TimeSent)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	defer rows.Close()
+
+	var returnMsg []eventMessage
+	for rows.Next() {
+		temp := new(eventMessage)
+		rows.Scan(&temp.MsgId, &temp.EventId, &temp.IdFrom, &temp.Msg, &temp.TimeSent)
+		returnMsg = append(returnMsg, *temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{returnMsg}))
+}
+
+func sendEventMessage(context *gin.Context) {
+	var newMessage eventMessage
+
+	if err := context.BindJSON(&newMessage); err != nil {
+		return
+	}
+
+	members, err := eventMembers(newMessage.EventId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	member := false
+	for _, item := range members {
+		if strconv.FormatInt(item, 10) == newMessage.IdFrom {
+			member = true
+			break
+		}
+	}
+	if !member {
+		context.IndentedJSON(http.StatusForbidden, newResponse("not a participant", []interface{}{newMessage}))
+		return
+	}
+
+	sqlstatement := `
+	INSERT INTO event_messages (event_id, id_from, msg, time_sent)
+	VALUES ($1, $2, $3, current_timestamp)
+	RETURNING msg_id, event_id, id_from, msg, time_sent;`
+
+	var temp eventMessage
+	err = dataBase.QueryRow(sqlstatement, newMessage.EventId, newMessage.IdFrom, newMessage.Msg).Scan(&temp.MsgId, &temp.EventId, &temp.IdFrom, &temp.Msg, &temp.TimeSent)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	for _, item := range members {
+		server.send(strconv.FormatInt(item, 10), "event_message", temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
+}
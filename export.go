@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how many messages are written between flushes to the client
+const exportFlushEvery = 100
+
+const exportTimeLayout = "2006-01-02 15:04"
+
+type exportRequest struct {
+	Id     string `json:"id"`
+	PeerId string `json:"peer_id"`
+	Format string `json:"format"`
+}
+
+// writes an exported conversation one message at a time
+type exportWriter interface {
+	write(m message) error
+	close() error
+}
+
+// a JSON array of messages
+type jsonExportWriter struct {
+	w       io.Writer
+	written int
+}
+
+func (e *jsonExportWriter) write(m message) error {
+	sep := ",\n"
+	if e.written == 0 {
+		sep = "[\n"
+	}
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	e.written++
+	_, err = e.w.Write(encoded)
+	return err
+}
+
+func (e *jsonExportWriter) close() error {
+	end := "\n]\n"
+	if e.written == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+// a transcript with one line per message, continuation lines are indented
+type textExportWriter struct {
+	w     io.Writer
+	names map[string]string
+}
+
+func newTextExportWriter(w io.Writer, names map[string]string, now time.Time) (*textExportWriter, error) {
+	var ids []string
+	for id := range names {
+		ids = append(ids, id)
+	}
+	header := "Conversation"
+	if len(ids) == 2 {
+		a, b := names[ids[0]], names[ids[1]]
+		if a > b {
+			a, b = b, a
+		}
+		header = "Conversation between " + a + " and " + b
+	}
+
+	_, err := fmt.Fprintf(w, "%s\nExported %s UTC\n\n", header, now.UTC().Format(exportTimeLayout))
+	return &textExportWriter{w, names}, err
+}
+
+func (e *textExportWriter) write(m message) error {
+	name, ok := e.names[m.IdFrom]
+	if !ok {
+		name = m.IdFrom
+	}
+
+	text := strings.ReplaceAll(m.Msg, "\n", "\n    ")
+	switch {
+	case m.DeletedAt != nil:
+		text = "(message deleted)"
+	case len(m.Attachments) == 1:
+		text = strings.TrimSpace(text + " [1 image]")
+	case len(m.Attachments) > 1:
+		text = strings.TrimSpace(text + " [" + strconv.Itoa(len(m.Attachments)) + " images]")
+	}
+	if m.EditedAt != nil && m.DeletedAt == nil {
+		text += " (edited)"
+	}
+
+	_, err := fmt.Fprintf(e.w, "[%s] %s: %s\n", m.TimeSent.UTC().Format(exportTimeLayout), name, text)
+	return err
+}
+
+func (e *textExportWriter) close() error {
+	return nil
+}
+
+// names of the users that still have a profile, deleted accounts are left out
+func profileNames(ids ...string) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, id := range ids {
+		var name string
+		err := dataBase.QueryRow(`SELECT name FROM profile WHERE id = $1;`, id).Scan(&name)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, nil
+}
+
+// download a whole conversation, oldest message first, as JSON or a plain text transcript
+func exportConversation(context *gin.Context) {
+	var request exportRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if request.Format == "" {
+		request.Format = "json"
+	}
+	if request.Format != "json" && request.Format != "text" {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("format must be json or text", []interface{}{request}))
+		return
+	}
+
+	names, err := profileNames(request.Id, request.PeerId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	sqlstatement := `
+	SELECT ` + messageColumns + `,
+		(
+			SELECT COALESCE(json_agg(json_build_object(
+				'attachment_id', attachment_id,
+				'owner', owner::text,
+				'content_type', content_type,
+				'size', size,
+				'created_at', created_at
+			) ORDER BY attachment_id), '[]')
+			FROM attachments
+			WHERE attachments.msg_id = messaage.msg_id
+		)
+	FROM messaage
+	WHERE (id_from = $1 AND id_to = $2)
+	OR (id_to = $1 AND id_from = $2)
+	ORDER BY time_sent ASC;`
+
+	rows, err := dataBase.Query(sqlstatement, request.Id, request.PeerId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	defer rows.Close()
+
+	filename := "conversation-" + request.Id + "-" + request.PeerId
+	out := bufio.NewWriter(context.Writer)
+
+	var writer exportWriter
+	if request.Format == "text" {
+		context.Header("Content-Type", "text/plain; charset=utf-8")
+		context.Header("Content-Disposition", `attachment; filename="`+filename+`.txt"`)
+		writer, err = newTextExportWriter(out, names, time.Now())
+	} else {
+		context.Header("Content-Type", "application/json; charset=utf-8")
+		context.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		writer = &jsonExportWriter{w: out}
+	}
+	context.Status(http.StatusOK)
+
+	// the status is sent by now, so errors past this point can only cut the download short
+	for count := 1; err == nil && rows.Next(); count++ {
+		var m message
+		var attachments []byte
+		if err = rows.Scan(&m.MsgId, &m.IdFrom, &m.IdTo, &m.Msg, &m.TimeSent, &m.DeliveredAt, &m.ReadAt, &m.EditedAt, &m.DeletedAt, &attachments); err != nil {
+			break
+		}
+		if err = json.Unmarshal(attachments, &m.Attachments); err != nil {
+			break
+		}
+		for i := range m.Attachments {
+			m.Attachments[i].Url = "/attachments/" + strconv.Itoa(m.Attachments[i].AttachmentId)
+		}
+
+		if err = writer.write(m); err != nil {
+			break
+		}
+		if count%exportFlushEvery == 0 {
+			if err = out.Flush(); err == nil {
+				context.Writer.Flush()
+			}
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = writer.close()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonExportWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &jsonExportWriter{w: &buf}
+	assert.NoError(t, w.close())
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	w = &jsonExportWriter{w: &buf}
+	sent := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	assert.NoError(t, w.write(message{MsgId: 1, IdFrom: "1", IdTo: "2", Msg: "hi", TimeSent: sent}))
+	assert.NoError(t, w.write(message{MsgId: 2, IdFrom: "2", IdTo: "1", Msg: "hey", TimeSent: sent}))
+	assert.NoError(t, w.close())
+
+	var out []message
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Len(t, out, 2)
+	assert.Equal(t, "hey", out[1].Msg)
+}
+
+func TestTextExportWriter(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	w, err := newTextExportWriter(&buf, map[string]string{"1": "Bob", "2": "Alice"}, now)
+	assert.NoError(t, err)
+
+	sent := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	w.write(message{IdFrom: "1", Msg: "hi\nthere", TimeSent: sent})
+	w.write(message{IdFrom: "2", Msg: "look", TimeSent: sent, EditedAt: &now, Attachments: []attachment{{}, {}}})
+	w.write(message{IdFrom: "2", TimeSent: sent, DeletedAt: &now})
+	assert.NoError(t, w.close())
+
+	assert.Equal(t, "Conversation between Alice and Bob\n"+
+		"Exported 2026-10-19 12:00 UTC\n\n"+
+		"[2026-10-19 09:00] Bob: hi\n    there\n"+
+		"[2026-10-19 09:00] Alice: look [2 images] (edited)\n"+
+		"[2026-10-19 09:00] Alice: (message deleted)\n", buf.String())
+}
+
+func TestExportConversationDeletedPeer(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/exportConversation", exportConversation)
+
+	me := createTestProfile(t, "export-test-me@example.com", "Me")
+	peer := createTestProfile(t, "export-test-peer@example.com", "Peer")
+	insertTestMessage(t, peer, me, "bye")
+
+	// the peer's account was deleted, their messages stay with me
+	_, err := dataBase.Exec(`DELETE FROM profile WHERE id = $1;`, peer)
+	assert.NoError(t, err)
+
+	w := performRequest(router, http.MethodPost, "/exportConversation", exportRequest{Id: me, PeerId: peer, Format: "text"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "] "+peer+": bye\n")
+}
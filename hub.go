@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// how long a write to a client may take before the connection is dropped
+const wsWriteTimeout = 10 * time.Second
+
+// real time events pushed to, and read from, connected clients
+type wsEvent struct {
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+// a single websocket connection belonging to a user
+type client struct {
+	id string
+	// the session the connection was opened with
+	sessionId int
+	conn      *websocket.Conn
+	mu        sync.Mutex
+}
+
+// handlers for events sent by clients, keyed by event type
+var wsHandlers = map[string]func(c *client, body json.RawMessage){}
+
+// global real time hub
+var server = newServer()
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func newServer() *Server {
+	return &Server{
+		conns:    make(map[*websocket.Conn]bool),
+		users:    make(map[string]map[*client]bool),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+func (c *client) write(ev wsEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(ev)
+}
+
+// register a connection, returns whether it is the user's first
+func (s *Server) add(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns[c.conn] = true
+	if s.users[c.id] == nil {
+		s.users[c.id] = make(map[*client]bool)
+	}
+	s.users[c.id][c] = true
+	return len(s.users[c.id]) == 1
+}
+
+// unregister a connection, returns whether it was the user's last
+func (s *Server) remove(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c.conn)
+	delete(s.users[c.id], c)
+	s.lastSeen[c.id] = time.Now()
+	if len(s.users[c.id]) == 0 {
+		delete(s.users, c.id)
+		return true
+	}
+	return false
+}
+
+// when the user's last connection was closed
+func (s *Server) seen(userId string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.lastSeen[userId]
+	return t, ok
+}
+
+// whether the user has at least one open connection
+func (s *Server) online(userId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users[userId]) > 0
+}
+
+// close every connection of a user
+func (s *Server) disconnect(userId string) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.users[userId]))
+	for c := range s.users[userId] {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// close the connections opened with a session
+func (s *Server) disconnectSession(userId string, sessionId int) {
+	s.mu.Lock()
+	clients := []*client{}
+	for c := range s.users[userId] {
+		if c.sessionId == sessionId {
+			clients = append(clients, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// push an event to every connection of a user, returns whether any connection received it
+func (s *Server) send(userId string, eventType string, body interface{}) bool {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.users[userId]))
+	for c := range s.users[userId] {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	delivered := false
+	for _, c := range clients {
+		if err := c.write(wsEvent{eventType, payload}); err != nil {
+			c.conn.Close()
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// upgrade to a websocket for the logged in user, see requireSocketSession
+func (s *Server) handleWs(context *gin.Context) {
+	current := currentSession(context)
+	userId := current.Id
+
+	conn, err := upgrader.Upgrade(context.Writer, context.Request, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	c := &client{id: userId, sessionId: current.SessionId, conn: conn}
+	if s.add(c) {
+		go broadcastPresence(userId)
+	}
+	defer func() {
+		if s.remove(c) {
+			go broadcastPresence(userId)
+		}
+		conn.Close()
+	}()
+
+	for {
+		var ev wsEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			return
+		}
+
+		if handler, ok := wsHandlers[ev.Type]; ok {
+			handler(c, ev.Body)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// connect to the hub with a session for the user
+func dialHub(t *testing.T, url string, id string) *websocket.Conn {
+	token := "hub-test-" + id
+	sessions.put(hashToken(token), session{Id: id}, time.Now())
+	t.Cleanup(func() { sessions.remove(hashToken(token)) })
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws?token="+token, nil)
+	assert.NoError(t, err)
+
+	// wait for the hub to register the connection
+	for i := 0; i < 100 && !server.online(id); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return conn
+}
+
+func TestHubSend(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.GET("/ws", requireSocketSession(), server.handleWs)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	conn := dialHub(t, ts.URL, "101")
+	defer conn.Close()
+
+	assert.True(t, server.online("101"))
+	assert.False(t, server.online("102"))
+	assert.False(t, server.send("102", "message", message{Msg: "hi"}))
+	assert.True(t, server.send("101", "message", message{MsgId: 7, Msg: "hi"}))
+
+	var ev wsEvent
+	assert.NoError(t, conn.ReadJSON(&ev))
+	assert.Equal(t, "message", ev.Type)
+	assert.Contains(t, string(ev.Body), `"msg_id":7`)
+
+	conn.Close()
+	for i := 0; i < 100 && server.online("101"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, server.online("101"))
+}
+
+func TestHubRequiresSession(t *testing.T) {
+	ts := startHub(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	_, response, err := websocket.DefaultDialer.Dial(url+"?id=101", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// the token also works as a header
+	sessions.put(hashToken("hub-test-header"), session{Id: "103"}, time.Now())
+	defer sessions.remove(hashToken("hub-test-header"))
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer hub-test-header"}})
+	assert.NoError(t, err)
+	defer conn.Close()
+	for i := 0; i < 100 && !server.online("103"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, server.online("103"))
+}
+
+func TestHubDisconnectSession(t *testing.T) {
+	ts := startHub(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?token="
+	sessions.put(hashToken("hub-test-phone"), session{SessionId: 1, Id: "104"}, time.Now())
+	sessions.put(hashToken("hub-test-laptop"), session{SessionId: 2, Id: "104"}, time.Now())
+	defer sessions.remove(hashToken("hub-test-phone"), hashToken("hub-test-laptop"))
+
+	phone, _, err := websocket.DefaultDialer.Dial(url+"hub-test-phone", nil)
+	assert.NoError(t, err)
+	defer phone.Close()
+	laptop, _, err := websocket.DefaultDialer.Dial(url+"hub-test-laptop", nil)
+	assert.NoError(t, err)
+	defer laptop.Close()
+	connections := func() int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.users["104"])
+	}
+	for i := 0; i < 100 && connections() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// only the revoked session's connection is closed
+	server.disconnectSession("104", 1)
+	phone.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = phone.ReadMessage()
+	assert.Error(t, err)
+
+	assert.True(t, server.send("104", "message", message{Msg: "hi"}))
+	assert.Equal(t, "message", readEvent(t, laptop, "message").Type)
+}
+
+// the next event of the given type, others such as presence updates are skipped
+func readEvent(t *testing.T, conn *websocket.Conn, eventType string) wsEvent {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var ev wsEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type == eventType {
+			return ev
+		}
+	}
+}
+
+func startHub(t *testing.T) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", requireSocketSession(), server.handleWs)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts
+}
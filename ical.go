@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// calendars have no end time to go on, so every entry gets the same length
+const defaultEventDuration = time.Hour
+
+// how long cancelled and removed events stay in feeds as cancelled entries
+const removedEventRetention = 30 * 24 * time.Hour
+
+const icalTimeLayout = "20060102T150405Z"
+
+type calendarToken struct {
+	Id    string `json:"id"`
+	Token string `json:"token"`
+	Url   string `json:"url"`
+}
+
+// builds an RFC 5545 calendar, lines are folded and end in CRLF
+type icalWriter struct {
+	b strings.Builder
+}
+
+func newCalendar() *icalWriter {
+	w := new(icalWriter)
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//Hot & Cold//Events//EN")
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	return w
+}
+
+// write a content line, folding it every 75 octets
+func (w *icalWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		// do not split a multi-byte character
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(s[:cut])
+		w.b.WriteString("\r\n ")
+		s = s[cut:]
+		// continuation lines start with a space
+		limit = 74
+	}
+	w.b.WriteString(s)
+	w.b.WriteString("\r\n")
+}
+
+func (w *icalWriter) String() string {
+	return w.b.String() + "END:VCALENDAR\r\n"
+}
+
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+func icalTime(t time.Time) string {
+	return t.UTC().Format(icalTimeLayout)
+}
+
+// every occurrence of an event shares its UID and is told apart by RECURRENCE-ID
+func eventUid(eventId string) string {
+	return "event-" + eventId + "@hotandcold"
+}
+
+// occurrences joined on their own are separate entries in a feed, with a UID of their own
+func occurrenceUid(eventId string, occurrence time.Time) string {
+	return "event-" + eventId + "-" + icalTime(occurrence) + "@hotandcold"
+}
+
+// write a single VEVENT, rrule and exdates are only set on the master entry of a series
+func (w *icalWriter) event(e event, status string, rrule string, exdates []time.Time) {
+	w.entry(eventUid(e.EventId), e, status, rrule, exdates)
+}
+
+func (w *icalWriter) entry(uid string, e event, status string, rrule string, exdates []time.Time) {
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + uid)
+	w.line("DTSTAMP:" + icalTime(time.Now()))
+	if e.Occurrence != nil {
+		w.line("RECURRENCE-ID:" + icalTime(*e.Occurrence))
+	}
+	w.line("DTSTART:" + icalTime(e.DateTime))
+	w.line("DTEND:" + icalTime(e.DateTime.Add(defaultEventDuration)))
+	if rrule != "" {
+		w.line("RRULE:" + rrule)
+	}
+	for _, t := range exdates {
+		w.line("EXDATE:" + icalTime(t))
+	}
+	w.line("SEQUENCE:" + strconv.Itoa(e.Sequence))
+	w.line("STATUS:" + status)
+	w.line("SUMMARY:" + icalEscape(e.Name))
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + icalEscape(e.Description))
+	}
+	w.line("END:VEVENT")
+}
+
+// write an event, recurring events become a master entry plus one entry per edited occurrence.
+// with a user set, occurrences they have left are excluded like cancelled ones.
+func (w *icalWriter) series(e event, overrides map[int64]occurrenceOverride, userId int64) {
+	if e.RRule == "" {
+		w.event(e, "CONFIRMED", "", nil)
+		return
+	}
+
+	var exdates []time.Time
+	var edited []event
+	for unix, o := range overrides {
+		occurrence := time.Unix(unix, 0).UTC()
+		left := userId != 0 && o.UserIds != nil && !hasParticipant(event{UserIds: o.UserIds}, userId)
+		if o.Cancelled || left {
+			exdates = append(exdates, occurrence)
+			continue
+		}
+		if !o.Size.Valid && !o.Name.Valid && !o.Description.Valid && !o.DateTime.Valid {
+			// only the participant list differs
+			continue
+		}
+
+		temp := e
+		temp.DateTime = occurrence
+		temp.Occurrence = &occurrence
+		o.apply(&temp)
+		edited = append(edited, temp)
+	}
+	sort.Slice(exdates, func(i, j int) bool { return exdates[i].Before(exdates[j]) })
+	sort.Slice(edited, func(i, j int) bool { return edited[i].Occurrence.Before(*edited[j].Occurrence) })
+
+	w.event(e, "CONFIRMED", e.RRule, exdates)
+	for _, item := range edited {
+		w.event(item, "CONFIRMED", "", nil)
+	}
+}
+
+// write the occurrences of a series a user joined one by one, without joining the series.
+// with cancelled set they are all written as cancelled, for a series that was cancelled.
+func (w *icalWriter) joinedOccurrences(e event, overrides map[int64]occurrenceOverride, userId int64, from, to time.Time, cancelled bool) error {
+	rule, err := parseRRule(e.RRule)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range rule.between(e.DateTime, from, to) {
+		o, ok := overrides[t.Unix()]
+		if !ok || !hasParticipant(event{UserIds: o.UserIds}, userId) {
+			continue
+		}
+
+		temp := e
+		temp.DateTime = t
+		o.apply(&temp)
+		status := "CONFIRMED"
+		if o.Cancelled || cancelled {
+			status = "CANCELLED"
+		}
+		w.entry(occurrenceUid(e.EventId, t), temp, status, "", nil)
+	}
+	return nil
+}
+
+func writeCalendar(context *gin.Context, filename string, cal *icalWriter) {
+	context.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	context.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(cal.String()))
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// export a single event as an .ics file
+func exportEvent(context *gin.Context) {
+	var temp event
+	err := scanEvent(dataBase.QueryRow(`SELECT `+eventColumns+` FROM events WHERE event_id = $1;`, context.Param("id")), &temp)
+
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("event not found", []interface{}{context.Param("id")}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{context.Param("id")}))
+		return
+	}
+
+	var overrides map[int64]occurrenceOverride
+	if temp.RRule != "" {
+		if overrides, err = loadOverrides(temp.EventId); err != nil {
+			context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{temp}))
+			return
+		}
+	}
+
+	cal := newCalendar()
+	cal.series(temp, overrides, 0)
+
+	writeCalendar(context, "event-"+temp.EventId+".ics", cal)
+}
+
+// create, or with rotate set replace, the token for the logged in user's calendar feed
+func getCalendarToken(context *gin.Context) {
+	var request struct {
+		Id     string `json:"id"`
+		Rotate bool   `json:"rotate"`
+	}
+
+	// the body is optional
+	if err := context.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	request.Id = currentSession(context).Id
+
+	token, err := generateToken()
+	if err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	statement := `
+		INSERT INTO calendar_tokens (id, token)
+		VALUES ($1, $2)
+		ON CONFLICT (id)
+		DO UPDATE SET token = CASE WHEN $3 THEN $2 ELSE calendar_tokens.token END
+		RETURNING token;
+	`
+	err = dataBase.QueryRow(statement, request.Id, token, request.Rotate).Scan(&token)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	ret := calendarToken{request.Id, token, "/calendar/" + token + ".ics"}
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
+
+// calendar feed of every event the token's owner has joined
+func calendarFeed(context *gin.Context) {
+	token := strings.TrimSuffix(context.Param("token"), ".ics")
+
+	var userId int64
+	err := dataBase.QueryRow(`SELECT id FROM calendar_tokens WHERE token = $1;`, token).Scan(&userId)
+
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("calendar not found", []interface{}{}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	cal := newCalendar()
+	if err = writeJoinedEvents(cal, userId); err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	if err = writeRemovedEvents(cal, userId); err != nil {
+		context.IndentedJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	writeCalendar(context, "hotandcold.ics", cal)
+}
+
+// the upcoming events the user has joined. series they joined are written whole, as in exportEvent,
+// occurrences they joined on their own are written as entries of their own.
+func writeJoinedEvents(cal *icalWriter, userId int64) error {
+	rows, err := dataBase.Query(`
+		SELECT `+eventColumns+` FROM events
+		WHERE status = 'active' AND (datetime > now() OR rrule != '')
+		AND ($1 = ANY(user_id) OR event_id IN (SELECT event_id FROM event_occurrences WHERE $1 = ANY(user_id)));
+	`, userId)
+	if err != nil {
+		return err
+	}
+
+	var events []event
+	for rows.Next() {
+		var temp event
+		if err = scanEvent(rows, &temp); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, temp)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, e := range events {
+		if e.RRule == "" {
+			// the same single events getJoinedEvent returns
+			if len(e.UserIds) < e.Size && hasParticipant(e, userId) {
+				cal.event(e, "CONFIRMED", "", nil)
+			}
+			continue
+		}
+
+		overrides, err := loadOverrides(e.EventId)
+		if err != nil {
+			return err
+		}
+		if hasParticipant(e, userId) {
+			cal.series(e, overrides, userId)
+		} else if err = cal.joinedOccurrences(e, overrides, userId, now, now.Add(occurrenceWindow), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// events cancelled or removed recently that the user had joined
+func writeRemovedEvents(cal *icalWriter, userId int64) error {
+	rows, err := dataBase.Query(`
+		SELECT event_id, user_id, name, datetime, rrule, sequence, false
+		FROM events
+		WHERE status = 'cancelled' AND cancelled_at > $2
+		AND ($1 = ANY(user_id) OR event_id IN (SELECT event_id FROM event_occurrences WHERE $1 = ANY(user_id)))
+		UNION ALL
+		SELECT event_id, user_id, name, datetime, rrule, sequence, true
+		FROM removed_events
+		WHERE $1 = ANY(user_id) AND removed_at > $2;
+	`, userId, time.Now().Add(-removedEventRetention))
+	if err != nil {
+		return err
+	}
+
+	var events []event
+	var removed []bool
+	for rows.Next() {
+		var temp event
+		var gone bool
+		if err = rows.Scan(&temp.EventId, pq.Array(&temp.UserIds), &temp.Name, &temp.DateTime, &temp.RRule, &temp.Sequence, &gone); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, temp)
+		removed = append(removed, gone)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, e := range events {
+		// cancelling the master entry of a series cancels every occurrence. removed events only
+		// list the series' participants, their occurrences went with them.
+		if e.RRule == "" || removed[i] || hasParticipant(e, userId) {
+			cal.event(e, "CANCELLED", e.RRule, nil)
+			continue
+		}
+
+		overrides, err := loadOverrides(e.EventId)
+		if err != nil {
+			return err
+		}
+		if err = cal.joinedOccurrences(e, overrides, userId, now, now.Add(occurrenceWindow), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
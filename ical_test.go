@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIcalEscape(t *testing.T) {
+	assert.Equal(t, `a\, b\; c\\d\ne`, icalEscape("a, b; c\\d\ne"))
+}
+
+func TestIcalFolding(t *testing.T) {
+	w := new(icalWriter)
+	w.line("SUMMARY:" + strings.Repeat("x", 200))
+
+	lines := strings.Split(strings.TrimSuffix(w.b.String(), "\r\n"), "\r\n")
+	assert.Len(t, lines, 3)
+	for _, l := range lines {
+		assert.LessOrEqual(t, len(l), 75)
+	}
+	assert.Equal(t, "SUMMARY:"+strings.Repeat("x", 200), strings.ReplaceAll(strings.Join(lines, "\r\n"), "\r\n ", ""))
+}
+
+func TestIcalEvent(t *testing.T) {
+	start := time.Date(2026, 11, 2, 18, 0, 0, 0, time.UTC)
+	e := event{EventId: "12", Name: "Meetup, weekly", DateTime: start, Sequence: 3}
+
+	cal := newCalendar()
+	cal.event(e, "CONFIRMED", "FREQ=WEEKLY", nil)
+	out := cal.String()
+
+	assert.Contains(t, out, "UID:event-12@hotandcold\r\n")
+	assert.Contains(t, out, "DTSTART:20261102T180000Z\r\n")
+	assert.Contains(t, out, "DTEND:20261102T190000Z\r\n")
+	assert.Contains(t, out, "RRULE:FREQ=WEEKLY\r\n")
+	assert.Contains(t, out, "SEQUENCE:3\r\n")
+	assert.Contains(t, out, "SUMMARY:Meetup\\, weekly\r\n")
+	assert.NotContains(t, out, "RECURRENCE-ID")
+	assert.True(t, strings.HasSuffix(out, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+}
+
+func TestIcalSeries(t *testing.T) {
+	start := time.Date(2026, 11, 2, 18, 0, 0, 0, time.UTC)
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+	e := event{EventId: "12", Name: "Meetup", DateTime: start, RRule: "FREQ=WEEKLY", UserIds: []int64{1, 2}}
+	overrides := map[int64]occurrenceOverride{
+		week(1).Unix(): {Cancelled: true},
+		week(2).Unix(): {UserIds: []int64{1}, Name: sql.NullString{String: "Meetup at the library", Valid: true}},
+		week(3).Unix(): {UserIds: []int64{1}},
+	}
+
+	// an export has the master entry, the cancelled occurrence excluded and the edited one on its own
+	cal := newCalendar()
+	cal.series(e, overrides, 0)
+	out := cal.String()
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VEVENT"))
+	assert.Contains(t, out, "RRULE:FREQ=WEEKLY\r\n")
+	assert.Contains(t, out, "EXDATE:20261109T180000Z\r\n")
+	assert.Contains(t, out, "RECURRENCE-ID:20261116T180000Z\r\n")
+	assert.Contains(t, out, "SUMMARY:Meetup at the library\r\n")
+	assert.NotContains(t, out, "20261123T180000Z")
+
+	// in user 2's feed the occurrences they left are excluded too
+	cal = newCalendar()
+	cal.series(e, overrides, 2)
+	out = cal.String()
+	assert.Equal(t, 1, strings.Count(out, "BEGIN:VEVENT"))
+	assert.Contains(t, out, "EXDATE:20261109T180000Z\r\nEXDATE:20261116T180000Z\r\nEXDATE:20261123T180000Z\r\n")
+	assert.NotContains(t, out, "RECURRENCE-ID")
+}
+
+func TestIcalJoinedOccurrences(t *testing.T) {
+	start := time.Date(2026, 11, 2, 18, 0, 0, 0, time.UTC)
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+	e := event{EventId: "12", Name: "Meetup", DateTime: start, RRule: "FREQ=WEEKLY", UserIds: []int64{1}}
+	overrides := map[int64]occurrenceOverride{
+		week(1).Unix(): {UserIds: []int64{1, 3}},
+		week(2).Unix(): {UserIds: []int64{1, 3}, Cancelled: true},
+		week(3).Unix(): {UserIds: []int64{1}},
+	}
+
+	cal := newCalendar()
+	assert.NoError(t, cal.joinedOccurrences(e, overrides, 3, start, week(10), false))
+	out := cal.String()
+
+	// entries of their own, never instances of a series the feed does not have
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VEVENT"))
+	assert.NotContains(t, out, "RECURRENCE-ID")
+	assert.NotContains(t, out, "RRULE")
+	assert.Contains(t, out, "UID:event-12-20261109T180000Z@hotandcold\r\n")
+	assert.Contains(t, out, "UID:event-12-20261116T180000Z@hotandcold\r\nDTSTAMP")
+	assert.Equal(t, 1, strings.Count(out, "STATUS:CANCELLED"))
+
+	// when the series is cancelled, so is every occurrence
+	cal = newCalendar()
+	assert.NoError(t, cal.joinedOccurrences(e, overrides, 3, start, week(10), true))
+	assert.Equal(t, 2, strings.Count(cal.String(), "STATUS:CANCELLED"))
+}
+
+func TestCalendarTokenRequiresSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/calendarToken", requireSession(), getCalendarToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/calendarToken", strings.NewReader(`{"id": "1"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
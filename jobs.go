@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// advisory lock held by whichever instance runs the jobs
+const jobsLockId = 7203
+
+// how often the scheduler looks for due jobs and tries to become leader
+const jobsTick = 30 * time.Second
+
+// a background job, run every interval by the leader
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+type scheduler struct {
+	jobs []job
+	tick time.Duration
+}
+
+// read a duration from the environment, e.g. JOB_GEOG_PURGE_INTERVAL=1h
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// interval of a job, configurable with JOB_<NAME>_INTERVAL, zero disables the job
+func jobInterval(name string, fallback time.Duration) time.Duration {
+	return envDuration("JOB_"+strings.ToUpper(name)+"_INTERVAL", fallback)
+}
+
+func defaultJobs() []job {
+	return []job{
+		{"event_reminders", jobInterval("event_reminders", 5*time.Minute), sendEventReminders},
+		{"geog_purge", jobInterval("geog_purge", time.Hour), purgeGeog},
+		{"event_purge", jobInterval("event_purge", 24*time.Hour), purgePastEvents},
+		{"swipe_expiry", jobInterval("swipe_expiry", 24*time.Hour), expireSwipes},
+		{"account_deletion", jobInterval("account_deletion", time.Hour), purgeDeletedAccounts},
+		{"session_purge", jobInterval("session_purge", 24*time.Hour), purgeSessions},
+		{"attachment_purge", jobInterval("attachment_purge", 24*time.Hour), purgeUnsentAttachments},
+	}
+}
+
+// run the default jobs in the background
+func startJobs() {
+	s := scheduler{defaultJobs(), jobsTick}
+	go s.run(context.Background())
+}
+
+func (s scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	var conn *sql.Conn
+	for {
+		if conn == nil {
+			conn = acquireJobsLock(ctx)
+		} else if err := conn.PingContext(ctx); err != nil {
+			// the lock went away with the connection
+			log.Println(err)
+			conn.Close()
+			conn = nil
+		}
+
+		if conn != nil {
+			s.runDue(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			if conn != nil {
+				releaseJobsLock(conn)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// try to become leader, the returned connection holds the lock
+func acquireJobsLock(ctx context.Context) *sql.Conn {
+	conn, err := dataBase.Conn(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1);`, jobsLockId).Scan(&locked)
+	if err != nil || !locked {
+		if err != nil {
+			log.Println(err)
+		}
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// advisory locks belong to the session, so unlock before handing the connection back to the pool
+func releaseJobsLock(conn *sql.Conn) {
+	_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, jobsLockId)
+	if err != nil {
+		log.Println(err)
+	}
+	conn.Close()
+}
+
+func (s scheduler) runDue(ctx context.Context) {
+	for _, j := range s.jobs {
+		if j.interval <= 0 {
+			continue
+		}
+
+		var nextRun time.Time
+		err := dataBase.QueryRowContext(ctx, `
+			INSERT INTO jobs (name, next_run)
+			VALUES ($1, current_timestamp)
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING next_run;
+		`, j.name).Scan(&nextRun)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		started := time.Now()
+		if started.Before(nextRun) {
+			continue
+		}
+
+		lastError := ""
+		if err = j.run(ctx); err != nil {
+			log.Printf("job %s: %s", j.name, err)
+			lastError = err.Error()
+		}
+
+		_, err = dataBase.ExecContext(ctx, `
+			UPDATE jobs SET last_run = $2, next_run = $3, last_error = $4
+			WHERE name = $1;
+		`, j.name, started, started.Add(j.interval), lastError)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// remind participants of events starting within EVENT_REMINDER_LEAD, once per occurrence
+func sendEventReminders(ctx context.Context) error {
+	now := time.Now()
+	lead := envDuration("EVENT_REMINDER_LEAD", time.Hour)
+
+	events, err := upcomingEvents(func(e event) bool {
+		return e.DateTime.Before(now.Add(lead))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		occurrence := e.DateTime
+		if e.Occurrence != nil {
+			occurrence = *e.Occurrence
+		}
+
+		// skip occurrences that were already reminded of
+		var reminded bool
+		err = dataBase.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM event_reminders WHERE event_id = $1 AND occurrence = $2);
+		`, e.EventId, occurrence).Scan(&reminded)
+		if err != nil {
+			return err
+		}
+		if reminded {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s starts at %s", e.Name, e.DateTime.UTC().Format(time.Kitchen+" UTC"))
+		failed := sendEach(e.UserIds, func(userId string) error {
+			return notify(userId, "event_reminder", e.Name, msg)
+		})
+		// nobody was reminded, try again on the next run
+		if len(e.UserIds) > 0 && failed == len(e.UserIds) {
+			continue
+		}
+
+		_, err = dataBase.ExecContext(ctx, `
+			INSERT INTO event_reminders (event_id, occurrence, reminded_at)
+			VALUES ($1, $2, current_timestamp)
+			ON CONFLICT DO NOTHING;
+		`, e.EventId, occurrence)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the events among candidates that are over, single events that started before cutoff and series whose last occurrence did
+func pastEvents(candidates []event, cutoff time.Time) ([]string, error) {
+	var ret []string
+	for _, e := range candidates {
+		if !e.DateTime.Before(cutoff) {
+			continue
+		}
+		if e.RRule != "" {
+			rule, err := parseRRule(e.RRule)
+			if err != nil {
+				return nil, err
+			}
+			if !rule.endedBefore(e.DateTime, cutoff) {
+				continue
+			}
+		}
+		ret = append(ret, e.EventId)
+	}
+	return ret, nil
+}
+
+// delete events over for longer than EVENT_RETENTION, cancelled ones included, with their chats, occurrences
+// and reminders. tombstones of removed events go once calendar feeds no longer show them.
+func purgePastEvents(ctx context.Context) error {
+	cutoff := time.Now().Add(-envDuration("EVENT_RETENTION", 30*24*time.Hour))
+
+	rows, err := dataBase.QueryContext(ctx, `SELECT `+eventColumns+` FROM events WHERE datetime < $1;`, cutoff)
+	if err != nil {
+		return err
+	}
+	var candidates []event
+	for rows.Next() {
+		var temp event
+		if err = scanEvent(rows, &temp); err != nil {
+			rows.Close()
+			return err
+		}
+		candidates = append(candidates, temp)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	past, err := pastEvents(candidates, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(past) > 0 {
+		if _, err = dataBase.ExecContext(ctx, `DELETE FROM events WHERE event_id = ANY($1::integer[]);`, pq.Array(past)); err != nil {
+			return err
+		}
+	}
+
+	_, err = dataBase.ExecContext(ctx, `DELETE FROM removed_events WHERE removed_at < $1;`, time.Now().Add(-removedEventRetention))
+	return err
+}
+
+// drop locations older than GEOG_RETENTION
+func purgeGeog(ctx context.Context) error {
+	cutoff := time.Now().Add(-envDuration("GEOG_RETENTION", 24*time.Hour))
+	_, err := dataBase.ExecContext(ctx, `DELETE FROM geog WHERE time < $1;`, cutoff)
+	return err
+}
+
+// let passed-on profiles show up in matches again after SWIPE_EXPIRY
+func expireSwipes(ctx context.Context) error {
+	cutoff := time.Now().Add(-envDuration("SWIPE_EXPIRY", 30*24*time.Hour))
+	_, err := dataBase.ExecContext(ctx, `DELETE FROM not_interested WHERE created_at < $1;`, cutoff)
+	return err
+}
+
+// drop sessions unused for SESSION_IDLE_TIMEOUT, they can no longer be used anyway
+func purgeSessions(ctx context.Context) error {
+	cutoff := time.Now().Add(-envDuration("SESSION_IDLE_TIMEOUT", 30*24*time.Hour))
+	_, err := dataBase.ExecContext(ctx, `DELETE FROM sessions WHERE last_used < $1;`, cutoff)
+	return err
+}
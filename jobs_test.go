@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobInterval(t *testing.T) {
+	assert.Equal(t, time.Hour, jobInterval("geog_purge", time.Hour))
+
+	t.Setenv("JOB_GEOG_PURGE_INTERVAL", "15m")
+	assert.Equal(t, 15*time.Minute, jobInterval("geog_purge", time.Hour))
+
+	t.Setenv("JOB_GEOG_PURGE_INTERVAL", "0")
+	assert.Equal(t, time.Duration(0), jobInterval("geog_purge", time.Hour))
+
+	t.Setenv("JOB_GEOG_PURGE_INTERVAL", "often")
+	assert.Equal(t, time.Hour, jobInterval("geog_purge", time.Hour))
+}
+
+func TestSendEach(t *testing.T) {
+	var sent []string
+	failed := sendEach([]int64{1, 2, 3}, func(userId string) error {
+		sent = append(sent, userId)
+		if userId == "2" {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	// a failure does not stop the rest
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, []string{"1", "2", "3"}, sent)
+}
+
+func TestPastEvents(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	ago := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	past, err := pastEvents([]event{
+		{EventId: "1", DateTime: ago(40)},
+		{EventId: "2", DateTime: ago(1)},
+		// ended long ago
+		{EventId: "3", DateTime: ago(100), RRule: "FREQ=WEEKLY;COUNT=3"},
+		// still going
+		{EventId: "4", DateTime: ago(100), RRule: "FREQ=WEEKLY"},
+		{EventId: "5", DateTime: ago(100), RRule: "FREQ=DAILY;UNTIL=20261201T000000Z"},
+	}, ago(30))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "3"}, past)
+}
+
+func TestSendEventReminders(t *testing.T) {
+	requireDatabase(t)
+
+	var eventId string
+	err := dataBase.QueryRow(`
+		INSERT INTO events (user_id, size, name, description, datetime, owner, rrule)
+		VALUES ('{1, 2}', 10, 'Reminder test', '', $1, 1, '')
+		RETURNING event_id;
+	`, time.Now().Add(30*time.Minute)).Scan(&eventId)
+	assert.NoError(t, err)
+	defer dataBase.Exec(`DELETE FROM events WHERE event_id = $1;`, eventId)
+	defer dataBase.Exec(`DELETE FROM notifications WHERE title = 'Reminder test';`)
+
+	// a second run does not remind again
+	assert.NoError(t, sendEventReminders(context.Background()))
+	assert.NoError(t, sendEventReminders(context.Background()))
+
+	var reminders, notifications int
+	dataBase.QueryRow(`SELECT count(*) FROM event_reminders WHERE event_id = $1;`, eventId).Scan(&reminders)
+	dataBase.QueryRow(`SELECT count(*) FROM notifications WHERE title = 'Reminder test';`).Scan(&notifications)
+	assert.Equal(t, 1, reminders)
+	assert.Equal(t, 2, notifications)
+}
+
+func TestPurgePastEvents(t *testing.T) {
+	requireDatabase(t)
+
+	var past, upcoming string
+	insert := `
+		INSERT INTO events (user_id, size, name, description, datetime, owner, rrule)
+		VALUES ('{1}', 10, 'Purge test', '', $1, 1, '')
+		RETURNING event_id;
+	`
+	assert.NoError(t, dataBase.QueryRow(insert, time.Now().AddDate(0, 0, -60)).Scan(&past))
+	assert.NoError(t, dataBase.QueryRow(insert, time.Now().AddDate(0, 0, 1)).Scan(&upcoming))
+	defer dataBase.Exec(`DELETE FROM events WHERE event_id = $1 OR event_id = $2;`, past, upcoming)
+
+	assert.NoError(t, purgePastEvents(context.Background()))
+
+	var left []string
+	rows, err := dataBase.Query(`SELECT event_id FROM events WHERE name = 'Purge test';`)
+	assert.NoError(t, err)
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		left = append(left, id)
+	}
+	rows.Close()
+	assert.Equal(t, []string{upcoming}, left)
+}
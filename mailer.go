@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sends plain text email
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// mailer used by the server, SMTP when MAIL_SMTP_ADDR is set and files under MAIL_DIR otherwise
+var mailer Mailer = newMailer()
+
+func newMailer() Mailer {
+	addr := envOr("MAIL_SMTP_ADDR", "")
+	if addr == "" {
+		return fileMailer{envOr("MAIL_DIR", "mail")}
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	var auth smtp.Auth
+	if user := envOr("MAIL_SMTP_USER", ""); user != "" {
+		auth = smtp.PlainAuth("", user, envOr("MAIL_SMTP_PASSWORD", ""), host)
+	}
+	return smtpMailer{addr, envOr("MAIL_FROM", "no-reply@hotandcold.app"), auth}
+}
+
+// format a message with the headers every mailer writes
+func composeMail(from string, to string, subject string, body string, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m smtpMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, composeMail(m.from, to, subject, body, time.Now()))
+}
+
+// writes each email to its own file, for local development
+type fileMailer struct {
+	dir string
+}
+
+func (m fileMailer) Send(to string, subject string, body string) error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.NewReplacer("/", "_", "\\", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.dir, name), composeMail("no-reply@localhost", to, subject, body, now), 0o600)
+}
+
+type sentMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// keeps sent email in memory, for tests
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func (m *memoryMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, sentMail{to, subject, body})
+	return nil
+}
+
+// the last email sent to an address
+func (m *memoryMailer) last(to string) (sentMail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+	return sentMail{}, false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	m := fileMailer{filepath.Join(t.TempDir(), "mail")}
+	assert.NoError(t, m.Send("a@example.com", "Reset your password", "line one\nline two"))
+
+	files, err := os.ReadDir(m.dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	content, err := os.ReadFile(filepath.Join(m.dir, files[0].Name()))
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(content), "To: a@example.com\r\n"))
+	assert.True(t, strings.Contains(string(content), "Subject: Reset your password\r\n"))
+	assert.True(t, strings.HasSuffix(string(content), "\r\n\r\nline one\r\nline two"))
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &memoryMailer{}
+	m.Send("a@example.com", "first", "1")
+	m.Send("b@example.com", "other", "2")
+	m.Send("a@example.com", "second", "3")
+
+	mail, ok := m.last("a@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "second", mail.Subject)
+
+	_, ok = m.last("c@example.com")
+	assert.False(t, ok)
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := generateToken()
+	assert.NoError(t, err)
+	assert.Len(t, token, 64)
+	assert.Equal(t, hashToken(token), hashToken(token))
+	assert.NotEqual(t, token, hashToken(token))
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -34,8 +35,9 @@ type profile struct {
 }
 
 type auth struct {
-	Email string `json:"email"`
-	Pwd   string `json:"pwd"`
+	Email  string `json:"email"`
+	Pwd    string `json:"pwd"`
+	Device string `json:"device,omitempty"`
 }
 
 type tag struct {
@@ -64,35 +66,63 @@ type response struct {
 }
 
 type message struct {
-	MsgId    int       `json:"msg_id"`
-	IdFrom   string    `json:"id_from"`
-	IdTo     string    `json:"id_to"`
-	Msg      string    `json:"msg"`
-	TimeSent time.Time `json:"time_sent"`
+	MsgId       int        `json:"msg_id"`
+	IdFrom      string     `json:"id_from"`
+	IdTo        string     `json:"id_to"`
+	Msg         string     `json:"msg"`
+	TimeSent    time.Time  `json:"time_sent"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at"`
+	EditedAt    *time.Time `json:"edited_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+
+	Attachments   []attachment `json:"attachments,omitempty"`
+	AttachmentIds []int        `json:"attachment_ids,omitempty"`
 }
 
 type Server struct {
-	conns map[*websocket.Conn]bool
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]bool
+	users    map[string]map[*client]bool
+	lastSeen map[string]time.Time
 }
 
 type event struct {
-	EventId     string    `json:"event_id"`
-	UserIds     []int64   `json:"user_id"`
-	Size        int       `json:"size"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	DateTime    time.Time `json:"date_time"`
-	Owner       string    `json:"owner"`
+	EventId      string     `json:"event_id"`
+	UserIds      []int64    `json:"user_id"`
+	Size         int        `json:"size"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	DateTime     time.Time  `json:"date_time"`
+	Owner        string     `json:"owner"`
+	RRule        string     `json:"rrule,omitempty"`
+	Occurrence   *time.Time `json:"occurrence,omitempty"`
+	Sequence     int        `json:"sequence"`
+	Status       string     `json:"status"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+}
+
+type cancelEventRequest struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type editEventRequest struct {
+	event
+	Scope     string `json:"scope"`
+	Cancelled bool   `json:"cancelled"`
 }
 
 type eventIdPair struct {
-	EventId string `json:"event_id"`
-	UserId  string `json:"user_id"`
+	EventId    string     `json:"event_id"`
+	UserId     string     `json:"user_id"`
+	Occurrence *time.Time `json:"occurrence,omitempty"`
 }
 
 type addEvent struct {
-	UserId  int64  `json:"id"`
-	EventId string `json:"event_id"`
+	UserId     int64      `json:"id"`
+	EventId    string     `json:"event_id"`
+	Occurrence *time.Time `json:"occurrence,omitempty"`
 }
 
 func newResponse(err_msg string, body []interface{}) response {
@@ -141,6 +171,11 @@ func addProfile(context *gin.Context) {
 		return
 	}
 
+	flagged, ok := filterContent(context, &newProfile.Bio, newProfile)
+	if !ok {
+		return
+	}
+
 	sqlStatement := `
 		INSERT INTO profile (id, name, age, bio, pfp)
 		VALUES ($1, $2, $3, $4, $5)`
@@ -149,6 +184,8 @@ func addProfile(context *gin.Context) {
 		panic(err)
 		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newProfile}))
 	} else {
+		flagForReview("profile", newProfile.ID, newProfile.ID, newProfile.Bio, flagged)
+		recordOnboarding(newProfile.ID)
 		context.IndentedJSON(http.StatusCreated, newResponse("ok", []interface{}{newProfile}))
 	}
 }
@@ -176,42 +213,144 @@ func generateSalt() string {
 	return string(b)
 }
 
+// hash a password the way it is stored in auth
+func hashPassword(pwd string, salt string) string {
+	hash := md5.Sum([]byte(pwd + salt))
+	return hex.EncodeToString(hash[:])
+}
+
+// registration can bring the first profile and tags, so the account is complete from the start
+type registerRequest struct {
+	auth
+	Profile *profile `json:"profile,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// create the account with its profile and tags in one transaction, returns the new id
+func createAccount(request registerRequest) (int, error) {
+	tx, err := dataBase.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId int
+	salt := generateSalt()
+	err = tx.QueryRow(`
+		INSERT INTO auth (email, salt, pwd)
+		VALUES ($1, $2, $3)
+		RETURNING id;
+	`, request.Email, salt, hashPassword(request.Pwd, salt)).Scan(&userId)
+	if err != nil {
+		return 0, err
+	}
+
+	if request.Profile != nil {
+		request.Profile.ID = strconv.Itoa(userId)
+		_, err = tx.Exec(`
+			INSERT INTO profile (id, name, age, bio, pfp)
+			VALUES ($1, $2, $3, $4, $5);
+		`, request.Profile.ID, request.Profile.Name, request.Profile.Age, request.Profile.Bio, request.Profile.Pfp)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, t := range request.Tags {
+		if _, err = tx.Exec(`INSERT INTO tags (id, tag) VALUES ($1, $2);`, userId, t); err != nil {
+			return 0, err
+		}
+	}
+
+	return userId, tx.Commit()
+}
+
+// the tags without repeats, false when there are more than a user may have
+func uniqueTags(tags []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	ret := []string{}
+	for _, t := range tags {
+		if !seen[t] {
+			seen[t] = true
+			ret = append(ret, t)
+		}
+	}
+	return ret, len(ret) <= maxTags
+}
+
 func register(context *gin.Context) {
-	var newAuth auth
+	var request registerRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+	newAuth := request.auth
+
+	if !validEmail(newAuth.Email) {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid email", []interface{}{newAuth}))
+		return
+	}
+
+	var ok bool
+	if request.Tags, ok = uniqueTags(request.Tags); !ok {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("Maximum amount of tags reached!", []interface{}{newAuth}))
+		return
+	}
+
+	var flagged []string
+	if request.Profile != nil {
+		if flagged, ok = filterContent(context, &request.Profile.Bio, newAuth); !ok {
+			return
+		}
+	}
 
-	if err := context.BindJSON(&newAuth); err != nil {
+	// registering taken emails is how accounts are enumerated, so those attempts are limited per IP
+	registerKey := "register:" + context.ClientIP()
+	if throttled(context, loginThrottle, registerKey) {
 		return
 	}
 
 	if emailExists(newAuth.Email, context) {
+		if err := loginThrottle.fail(time.Now(), registerKey); err != nil {
+			log.Println(err)
+		}
 		context.IndentedJSON(http.StatusConflict, "Email exists!")
-	} else {
-		salt := generateSalt()
-		salted := newAuth.Pwd + salt
-		hash := md5.Sum([]byte(salted))
-		pwd := hex.EncodeToString(hash[:])
-		var id int
-
-		//create new row in auth table
-		newAuthStatement := `
-			INSERT INTO auth (email, salt, pwd)
-			VALUES ($1, $2, $3)
-		`
-		_, err := dataBase.Exec(newAuthStatement, newAuth.Email, salt, pwd)
+		return
+	}
 
-		if err != nil {
-			panic(err)
-		} else {
-			err2 := dataBase.QueryRow("SELECT id FROM auth WHERE email=$1", newAuth.Email).Scan(&id)
-			if err2 != nil {
-				panic(err2)
-			}
-			context.IndentedJSON(http.StatusCreated, newResponse("ok", []interface{}{newAuth, id}))
+	userId, err := createAccount(request)
+	if err != nil {
+		// registered at the same moment by another request
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			context.IndentedJSON(http.StatusConflict, "Email exists!")
+			return
 		}
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newAuth}))
+		return
+	}
+
+	if err = sendVerification(userId, newAuth.Email); err != nil {
+		log.Println(err)
+	}
+
+	device := newAuth.Device
+	if device == "" {
+		device = context.Request.UserAgent()
+	}
+	token, err := createSession(userId, device, context.ClientIP())
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newAuth, userId}))
+		return
+	}
+
+	body := []interface{}{newAuth, userId, sessionToken{token}}
+	if request.Profile != nil {
+		flagForReview("profile", request.Profile.ID, request.Profile.ID, request.Profile.Bio, flagged)
+		body = append(body, *request.Profile)
 	}
+	context.IndentedJSON(http.StatusCreated, newResponse("ok", body))
 }
 
-// TODO: add authorisation to this
 func editProfile(context *gin.Context) {
 	var newProfile profile
 
@@ -219,6 +358,11 @@ func editProfile(context *gin.Context) {
 		return
 	}
 
+	flagged, ok := filterContent(context, &newProfile.Bio, newProfile)
+	if !ok {
+		return
+	}
+
 	editProfileStatement := `
 		UPDATE profile
 		SET name=$1, 
@@ -233,6 +377,8 @@ func editProfile(context *gin.Context) {
 		panic(err)
 		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newProfile}))
 	} else {
+		flagForReview("profile", newProfile.ID, newProfile.ID, newProfile.Bio, flagged)
+		recordOnboarding(newProfile.ID)
 		context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newProfile}))
 	}
 }
@@ -247,51 +393,105 @@ func login(context *gin.Context) {
 		return
 	}
 
+	keys := []string{accountKey(loginAuth.Email), ipKey(context.ClientIP())}
+	if throttled(context, loginThrottle, keys...) {
+		return
+	}
+
 	newLoginStatement := `
 		SELECT salt, pwd, id FROM auth WHERE email=$1
 	`
 	err := dataBase.QueryRow(newLoginStatement, loginAuth.Email).Scan(&recordedSalt, &recordedPwd, &recordedId)
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// email not found
-			context.IndentedJSON(http.StatusNotFound, newResponse("email not found", []interface{}{loginAuth}))
-		} else {
-			panic(err)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	// an unknown email and a wrong password get the same answer
+	userInputPassword := hashPassword(loginAuth.Pwd, recordedSalt)
+
+	if err == nil && userInputPassword == recordedPwd {
+		// pwd correct, users with 2FA finish logging in with loginTwoFactor
+		challenge, err := startTwoFactor(recordedId)
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{loginAuth}))
+			return
+		}
+		if challenge != "" {
+			// the account's failures are only cleared once the second factor passes too
+			context.IndentedJSON(http.StatusAccepted, newResponse("2fa required", []interface{}{twoFactorChallenge{Challenge: challenge}}))
+			return
+		}
+
+		if err = loginThrottle.succeed(keys[0]); err != nil {
+			log.Println(err)
 		}
+		completeLogin(context, recordedId, loginAuth.Device)
 	} else {
-		salted := loginAuth.Pwd + recordedSalt
-		hash := md5.Sum([]byte(salted))
-		userInputPassword := hex.EncodeToString(hash[:])
+		if err = loginThrottle.fail(time.Now(), keys...); err != nil {
+			log.Println(err)
+		}
+		context.IndentedJSON(http.StatusExpectationFailed, newResponse("email or pwd incorrect", []interface{}{loginAuth}))
+	}
+}
 
-		if userInputPassword == recordedPwd {
-			// pwd correct
-			returnProfile := retriveProfile(recordedId)
+// refuse the request while any of the keys is locked out, store errors let the request through
+func throttled(context *gin.Context, t throttle, keys ...string) bool {
+	wait, err := t.wait(time.Now(), keys...)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
 
-			if returnProfile == nullProf {
-				context.IndentedJSON(http.StatusFound, newResponse("error while retriving profile", []interface{}{returnProfile}))
-			}
+	context.Header("Retry-After", retryAfter(wait))
+	context.IndentedJSON(http.StatusTooManyRequests, newResponse("too many attempts", []interface{}{}))
+	return true
+}
 
-			context.IndentedJSON(http.StatusFound, newResponse("ok", []interface{}{returnProfile}))
-		} else {
-			//pwd not correct
-			context.IndentedJSON(http.StatusExpectationFailed, newResponse("pwd incorrect", []interface{}{loginAuth}))
-		}
+// respond to a successful login with the user's profile, a new session token and their onboarding status,
+// accounts without a profile yet log in too so they can add it
+func completeLogin(context *gin.Context, userId int, device string) {
+	message := "ok"
+	returnProfile, err := retriveProfile(userId)
+	if err == sql.ErrNoRows {
+		message = "profile missing"
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse("error while retriving profile", []interface{}{returnProfile}))
+		return
+	}
+
+	status, err := loadOnboardingStatus(returnProfile.ID)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{returnProfile}))
+		return
+	}
+
+	if device == "" {
+		device = context.Request.UserAgent()
+	}
+	token, err := createSession(userId, device, context.ClientIP())
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{returnProfile}))
+		return
 	}
+
+	context.IndentedJSON(http.StatusFound, newResponse(message, []interface{}{returnProfile, sessionToken{token}, status}))
 }
 
-func retriveProfile(id int) profile {
+// the user's profile, sql.ErrNoRows when they have not made one yet
+func retriveProfile(id int) (profile, error) {
 	var profile profile
 	err := dataBase.QueryRow(`SELECT age, bio, name, pfp FROM profile WHERE id=$1;`, id).Scan(&profile.Age, &profile.Bio, &profile.Name, &profile.Pfp)
 	profile.ID = strconv.Itoa(id)
-
-	if err != nil {
-		log.Fatal(err)
-		return nullProf
-	}
-	return profile
+	return profile, err
 }
 
+// the most tags a user may have
+const maxTags = 6
+
 func addTag(context *gin.Context) {
 	var myTag tag
 	var count int
@@ -313,7 +513,7 @@ func addTag(context *gin.Context) {
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{myTag}))
 	}
 
-	if count < 6 {
+	if count < maxTags {
 		// check if theres more than number allowed
 		checkRepeatedTagStatement := `
 			SELECT COUNT(tag)
@@ -338,6 +538,7 @@ func addTag(context *gin.Context) {
 			if err != nil {
 				panic(err)
 			} else {
+				recordOnboarding(myTag.Id)
 				context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{myTag}))
 			}
 		} else {
@@ -433,6 +634,7 @@ func updateGeog(context *gin.Context) {
 		log.Fatal(err)
 	}
 
+	recordOnboarding(geog.Id)
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{geog}))
 }
 
@@ -481,7 +683,8 @@ func matches(context *gin.Context) {
 		rows, err = dataBase.Query(`
 			SELECT * FROM tags WHERE tag=$1 AND 
 				((SELECT COUNT(*) FROM interested WHERE id_from=$2 AND id_to=tags.id) < 1) AND 
-				((SELECT COUNT(*) FROM not_interested WHERE id_from=$2 AND id_to=tags.id) < 1);
+				((SELECT COUNT(*) FROM not_interested WHERE id_from=$2 AND id_to=tags.id) < 1) AND
+				(SELECT verified FROM auth WHERE auth.id=tags.id);
 		`, tags[i].Tag, id.Id)
 		if err != nil {
 			log.Fatal(err)
@@ -582,6 +785,8 @@ func addInterest(context *gin.Context) {
 	$$;
 	`, newInterest.IdFrom, newInterest.IdTo, newInterest.IdFrom, newInterest.IdTo)
 
+	matchedBefore, _ := isMutualMatch(newInterest.IdFrom, newInterest.IdTo)
+
 	_, err := dataBase.Exec(sqlStatement)
 
 	if err != nil {
@@ -589,6 +794,12 @@ func addInterest(context *gin.Context) {
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newInterest}))
 	}
 
+	// tell both users the first time they match
+	if matched, err := isMutualMatch(newInterest.IdFrom, newInterest.IdTo); err == nil && matched && !matchedBefore {
+		notifyMatch(newInterest.IdFrom, newInterest.IdTo)
+		notifyMatch(newInterest.IdTo, newInterest.IdFrom)
+	}
+
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newInterest}))
 }
 
@@ -626,8 +837,13 @@ func getMessage(context *gin.Context) {
 		return
 	}
 
+	// whoever fetches the conversation has now received the peer's messages
+	if err := markConversationDelivered(newIdPair.IdFrom, newIdPair.IdTo); err != nil {
+		log.Println(err)
+	}
+
 	sqlstatement := `
-	SELECT * FROM messaage 
+	SELECT ` + messageColumns + ` FROM messaage 
 	WHERE ((id_from = $1 AND id_to = $2) 
 	OR (id_to = $1 AND id_from = $2)) 
 	AND (time_sent < $3) 
@@ -644,10 +860,15 @@ func getMessage(context *gin.Context) {
 	var returnMsg []message
 	for rows.Next() {
 		temp := new(message)
-		rows.Scan(&temp.MsgId, &temp.IdFrom, &temp.IdTo, &temp.Msg, &temp.TimeSent)
+		scanMessage(rows, temp)
 		returnMsg = append(returnMsg, *temp)
 	}
 
+	if err = loadAttachments(returnMsg); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newIdPair}))
+		return
+	}
+
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{returnMsg}))
 }
 
@@ -658,18 +879,66 @@ func sendMessage(context *gin.Context) {
 		return
 	}
 
+	flagged, ok := filterContent(context, &newMessage.Msg, newMessage)
+	if !ok {
+		return
+	}
+
+	refused, err := checkSend(newMessage)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	if refused != nil {
+		respondRefused(context, refused)
+		return
+	}
+
 	sqlstatement := `
 	INSERT INTO messaage (id_from, id_to, msg, time_sent) 
 	VALUES ($1, $2, $3, current_timestamp) 
-	RETURNING * ;`
+	RETURNING ` + messageColumns + ` ;`
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	defer tx.Rollback()
 
 	var temp message
-	err := dataBase.QueryRow(sqlstatement, newMessage.IdFrom, newMessage.IdTo, newMessage.Msg).Scan(&temp.MsgId, &temp.IdFrom, &temp.IdTo, &temp.Msg, &temp.TimeSent)
+	err = scanMessage(tx.QueryRow(sqlstatement, newMessage.IdFrom, newMessage.IdTo, newMessage.Msg), &temp)
 
 	if err != nil {
-		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	// a message is only sent with its attachments
+	err = attachToMessage(tx, &temp, newMessage.AttachmentIds)
+	if err == errAttachmentUnavailable {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	flagForReview("message", strconv.Itoa(temp.MsgId), temp.IdFrom, temp.Msg, flagged)
+
+	if server.send(temp.IdTo, "message", temp) {
+		if err = markMessageDelivered(&temp); err != nil {
+			log.Println(err)
+		}
+	} else {
+		go pushMessage(temp)
 	}
+	server.send(temp.IdFrom, "message", temp)
 
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
 
@@ -683,10 +952,12 @@ func getChat(context *gin.Context) {
 	}
 
 	sqlstatement := `
-		SELECT id, name, age, bio, pfp
+		SELECT profile.id, name, age, bio, pfp, geog.time
 		FROM profile
 		JOIN interested AS outer_interest
 		ON profile.id = outer_interest.id_to
+		LEFT JOIN geog
+		ON geog.id = profile.id
 		WHERE outer_interest.id_from = $1
 		AND (
 			SELECT COUNT(*)
@@ -699,18 +970,21 @@ func getChat(context *gin.Context) {
 
 	rows, err := dataBase.Query(sqlstatement, newId.Id)
 
-	var ret []profile
-	for rows.Next() {
-		temp := new(profile)
-		rows.Scan(&temp.ID, &temp.Name, &temp.Age, &temp.Bio, &temp.Pfp)
-		ret = append(ret, *temp)
-	}
-
 	if err != nil {
 		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newId}))
 	}
 
+	var ret []chatProfile
+	for rows.Next() {
+		temp := new(chatProfile)
+		var geogTime *time.Time
+		rows.Scan(&temp.ID, &temp.Name, &temp.Age, &temp.Bio, &temp.Pfp, &geogTime)
+		temp.Online = server.online(temp.ID)
+		temp.LastSeen = lastSeen(temp.ID, geogTime)
+		ret = append(ret, *temp)
+	}
+
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
 }
 
@@ -722,95 +996,292 @@ func setEvent(context *gin.Context) {
 		return
 	}
 
+	flagged, ok := filterContent(context, &newEvent.Description, newEvent)
+	if !ok {
+		return
+	}
+
+	// the logged in user owns the event and is its first participant
+	owner := currentSession(context).Id
+	ownerId, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+	userIds := []int64{ownerId}
+	for _, userId := range newEvent.UserIds {
+		if userId != ownerId {
+			userIds = append(userIds, userId)
+		}
+	}
+	newEvent.UserIds = userIds
+
+	if newEvent.RRule != "" {
+		rule, err := parseRRule(newEvent.RRule)
+		if err != nil {
+			context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newEvent}))
+			return
+		}
+		newEvent.RRule = rule.String()
+	}
+
 	statement := `
-		INSERT INTO events (user_id, size, name, description, datetime, owner)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING * ;
+		INSERT INTO events (user_id, size, name, description, datetime, owner, rrule)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + eventColumns + `;
 	`
 
 	var temp event
-	err := dataBase.QueryRow(
+	err = scanEvent(dataBase.QueryRow(
 		statement,
 		pq.Array(newEvent.UserIds),
 		newEvent.Size,
 		newEvent.Name,
 		newEvent.Description,
 		newEvent.DateTime,
-		newEvent.UserIds[0]).Scan(
-		&temp.EventId,
-		pq.Array(&temp.UserIds),
-		&temp.Size,
-		&temp.Description,
-		&temp.Name,
-		&temp.DateTime,
-		&temp.Owner)
+		owner,
+		newEvent.RRule), &temp)
 
 	if err != nil {
 		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
 	}
 
+	flagForReview("event", temp.EventId, temp.Owner, temp.Description, flagged)
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
 }
 
 func editEvent(context *gin.Context) {
-	var newEvent event
+	var newEvent editEventRequest
 
 	if err := context.BindJSON(&newEvent); err != nil {
 		fmt.Print(err)
 		return
 	}
 
+	flagged, ok := filterContent(context, &newEvent.Description, newEvent)
+	if !ok {
+		return
+	}
+
+	if newEvent.Scope == "occurrence" {
+		editOccurrence(context, newEvent, flagged)
+		return
+	}
+
+	// an empty rrule leaves the recurrence as it is
+	if newEvent.RRule != "" {
+		rule, err := parseRRule(newEvent.RRule)
+		if err != nil {
+			context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newEvent}))
+			return
+		}
+		newEvent.RRule = rule.String()
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+	defer tx.Rollback()
+
+	var previous event
+	err = scanEvent(tx.QueryRow(`SELECT `+eventColumns+` FROM events WHERE event_id = $1 FOR UPDATE;`, newEvent.EventId), &previous)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("event not found", []interface{}{newEvent}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+	if previous.Owner != currentSession(context).Id {
+		context.IndentedJSON(http.StatusForbidden, newResponse("not the event owner", []interface{}{newEvent}))
+		return
+	}
+
 	statement := `
 		UPDATE events 
-		SET size = $1, name = $2, description = $3, datetime= $4
-		WHERE event_id = $5
-		RETURNING *;
+		SET size = $1, name = $2, description = $3, datetime= $4, rrule = COALESCE(NULLIF($5, ''), rrule), sequence = sequence + 1
+		WHERE event_id = $6
+		RETURNING ` + eventColumns + `;
 	`
 
 	var temp event
-	err := dataBase.QueryRow(
+	err = scanEvent(tx.QueryRow(
 		statement,
 		newEvent.Size,
 		newEvent.Name,
 		newEvent.Description,
 		newEvent.DateTime,
-		newEvent.EventId).Scan(
-		&temp.EventId,
-		pq.Array(&temp.UserIds),
-		&temp.Size,
-		&temp.Description,
-		&temp.Name,
-		&temp.DateTime,
-		&temp.Owner)
+		newEvent.RRule,
+		newEvent.EventId), &temp)
 
 	if err != nil {
-		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+
+	// overrides follow the series to its new start, or go when they no longer fall on an occurrence
+	if temp.RRule != "" && (!temp.DateTime.Equal(previous.DateTime) || temp.RRule != previous.RRule) {
+		if err = moveOverrides(tx, temp.EventId, previous.DateTime, temp); err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
 	}
 
+	flagForReview("event", temp.EventId, temp.Owner, temp.Description, flagged)
+	if err = notifyEventMembers(temp.EventId, "event_updated", temp.Name, temp.Name+" has been updated"); err != nil {
+		log.Println(err)
+	}
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
 }
 
+// edit or cancel a single occurrence of a recurring event, the rest of the series is untouched
+func editOccurrence(context *gin.Context, newEvent editEventRequest, flagged []string) {
+	if newEvent.Occurrence == nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("occurrence required", []interface{}{newEvent}))
+		return
+	}
+
+	// a cancelled occurrence can be edited, to restore it
+	current, err := loadOccurrence(newEvent.EventId, newEvent.Occurrence)
+	if err != nil && err != errOccurrenceCancelled {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+	if current.Owner != currentSession(context).Id {
+		context.IndentedJSON(http.StatusForbidden, newResponse("not the event owner", []interface{}{newEvent}))
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+	defer tx.Rollback()
+
+	// fields left out keep the occurrence's current value, from an earlier edit or the series
+	changes := occurrenceChanges(newEvent.event)
+	statement := `
+		INSERT INTO event_occurrences (event_id, occurrence, user_id, size, name, description, datetime, cancelled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (event_id, occurrence)
+		DO UPDATE SET
+			size = COALESCE($4, event_occurrences.size),
+			name = COALESCE($5, event_occurrences.name),
+			description = COALESCE($6, event_occurrences.description),
+			datetime = COALESCE($7, event_occurrences.datetime),
+			cancelled = $8;
+	`
+	_, err = tx.Exec(
+		statement,
+		newEvent.EventId,
+		newEvent.Occurrence,
+		pq.Array(current.UserIds),
+		changes.Size,
+		changes.Name,
+		changes.Description,
+		changes.DateTime,
+		newEvent.Cancelled)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+
+	// calendar entries of the whole series share a sequence number
+	_, err = tx.Exec(`UPDATE events SET sequence = sequence + 1 WHERE event_id = $1;`, newEvent.EventId)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+
+	current.Sequence++
+	changes.apply(&current)
+	flagForReview("event", current.EventId, current.Owner, current.Description, flagged)
+	msg := current.Name + " on " + newEvent.Occurrence.UTC().Format("Jan 2") + " has been updated"
+	if newEvent.Cancelled {
+		msg = current.Name + " on " + newEvent.Occurrence.UTC().Format("Jan 2") + " has been cancelled"
+	}
+	if err = notifyEventMembers(current.EventId, "event_updated", current.Name, msg); err != nil {
+		log.Println(err)
+	}
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{current}))
+}
+
+// cancel an event, it is kept for history and every participant is notified. only the
+// owner or an admin may cancel it.
 func removeEvent(context *gin.Context) {
-	var newEventId id
+	var newEventId cancelEventRequest
 
 	if err := context.BindJSON(&newEventId); err != nil {
 		fmt.Print(err)
 		return
 	}
 
+	// only the owner or an admin may cancel
+	userId := currentSession(context).Id
+	var owner string
+	err := dataBase.QueryRow(`SELECT owner FROM events WHERE event_id = $1;`, newEventId.Id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("event not found or already cancelled", []interface{}{newEventId}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
+		return
+	}
+	if owner != userId {
+		admin, err := isAdmin(userId)
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
+			return
+		}
+		if !admin {
+			context.IndentedJSON(http.StatusForbidden, newResponse("not the event owner", []interface{}{newEventId}))
+			return
+		}
+	}
+
 	statement := `
-		DELETE from events WHERE event_id = $1;
+		UPDATE events
+		SET status = 'cancelled', cancel_reason = $2, cancelled_at = current_timestamp, sequence = sequence + 1
+		WHERE event_id = $1 AND status = 'active'
+		RETURNING ` + eventColumns + `;
 	`
-	_, err := dataBase.Exec(statement, newEventId.Id)
 
-	if err != nil {
-		log.Fatal(err)
+	var temp event
+	err = scanEvent(dataBase.QueryRow(statement, newEventId.Id, newEventId.Reason), &temp)
+
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusNotFound, newResponse("event not found or already cancelled", []interface{}{newEventId}))
+		return
+	} else if err != nil {
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
+		return
 	}
 
-	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newEventId}))
+	msg := temp.Name + " has been cancelled"
+	if temp.CancelReason != "" {
+		msg += ": " + temp.CancelReason
+	}
+	if err = notifyEventMembers(temp.EventId, "event_cancelled", temp.Name, msg); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
 }
 
 func removeIdFromEvent(context *gin.Context) {
@@ -821,6 +1292,30 @@ func removeIdFromEvent(context *gin.Context) {
 		return
 	}
 
+	if newEventId.Occurrence != nil {
+		current, err := loadOccurrence(newEventId.EventId, newEventId.Occurrence)
+		if err != nil {
+			context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{newEventId}))
+			return
+		}
+
+		statement := `
+			INSERT INTO event_occurrences (event_id, occurrence, user_id)
+			VALUES ($1, $2, array_remove($3::bigint[], $4))
+			ON CONFLICT (event_id, occurrence)
+			DO UPDATE SET user_id = array_remove(event_occurrences.user_id, $4);
+		`
+		_, err = dataBase.Exec(statement, newEventId.EventId, newEventId.Occurrence, pq.Array(current.UserIds), newEventId.UserId)
+
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
+			return
+		}
+
+		context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newEventId}))
+		return
+	}
+
 	statement := `
 		UPDATE events 
 		SET user_id = array_remove(user_id, $1)
@@ -833,59 +1328,54 @@ func removeIdFromEvent(context *gin.Context) {
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
 	}
 
+	// leaving a series also leaves every occurrence that has its own participant list
+	_, err = dataBase.Exec(`
+		UPDATE event_occurrences
+		SET user_id = array_remove(user_id, $1)
+		WHERE event_id = $2;
+	`, newEventId.UserId, newEventId.EventId)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEventId}))
+		return
+	}
+
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newEventId}))
 }
 
 func getEvent(context *gin.Context) {
-	events := []event{}
-
-	rows, err := dataBase.Query(`SELECT * FROM events WHERE datetime > now() AND array_length(user_id, 1) < size;`)
+	events, err := upcomingEvents(func(e event) bool {
+		return len(e.UserIds) < e.Size
+	})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for rows.Next() {
-		temp := new(event)
-		rows.Scan(
-			&temp.EventId,
-			pq.Array(&temp.UserIds),
-			&temp.Size,
-			&temp.Description,
-			&temp.Name,
-			&temp.DateTime,
-			&temp.Owner)
-		events = append(events, *temp)
-	}
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{events}))
 }
 
 func getJoinedEvent(context *gin.Context) {
 	var id id
-	events := []event{}
 	if err := context.BindJSON(&id); err != nil {
 		fmt.Print(err)
 		return
 	}
 
-	rows, err := dataBase.Query(`SELECT * FROM events WHERE datetime > now() AND array_length(user_id, 1) < size AND $1 = ANY(user_id);`, id.Id)
+	userId, err := strconv.ParseInt(id.Id, 10, 64)
+	if err != nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{id}))
+		return
+	}
+
+	events, err := upcomingEvents(func(e event) bool {
+		return len(e.UserIds) < e.Size && hasParticipant(e, userId)
+	})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for rows.Next() {
-		temp := new(event)
-		rows.Scan(
-			&temp.EventId,
-			pq.Array(&temp.UserIds),
-			&temp.Size,
-			&temp.Description,
-			&temp.Name,
-			&temp.DateTime,
-			&temp.Owner)
-		events = append(events, *temp)
-	}
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{events}))
 }
 
@@ -898,52 +1388,75 @@ func addToEvent(context *gin.Context) {
 	}
 
 	// check for duplicates
-	duplicateStatement := `
-		SELECT user_id, size FROM events WHERE event_id = $1
-	`
-	var tempId []int64
-	var tempSize int
-
-	err := dataBase.QueryRow(duplicateStatement, newEvent.EventId).Scan(pq.Array(&tempId), &tempSize)
+	current, err := loadOccurrence(newEvent.EventId, newEvent.Occurrence)
 
 	if err != nil {
-		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
 	}
 
-	for _, item := range tempId {
-		if newEvent.UserId == item {
-			context.IndentedJSON(http.StatusOK, newResponse("Already added", []interface{}{newEvent}))
-			return
-		}
+	if current.Status == "cancelled" {
+		context.IndentedJSON(http.StatusOK, newResponse("Event cancelled", []interface{}{newEvent}))
+		return
+	}
+
+	if hasParticipant(current, newEvent.UserId) {
+		context.IndentedJSON(http.StatusOK, newResponse("Already added", []interface{}{newEvent}))
+		return
 	}
 
 	// check for size
-	if len(tempId) >= tempSize {
+	if len(current.UserIds) >= current.Size {
 		context.IndentedJSON(http.StatusOK, newResponse("Event full", []interface{}{newEvent}))
 		return
 	}
 
+	if newEvent.Occurrence != nil {
+		statement := `
+			INSERT INTO event_occurrences (event_id, occurrence, user_id)
+			VALUES ($1, $2, array_append($3::bigint[], $4))
+			ON CONFLICT (event_id, occurrence)
+			DO UPDATE SET user_id = array_append(event_occurrences.user_id, $4);
+		`
+		_, err = dataBase.Exec(statement, newEvent.EventId, newEvent.Occurrence, pq.Array(current.UserIds), newEvent.UserId)
+
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+			return
+		}
+
+		current.UserIds = append(current.UserIds, newEvent.UserId)
+		context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{current}))
+		return
+	}
+
 	statement := `
 		UPDATE events SET user_id = array_append(user_id, $1) WHERE event_id = $2
-		RETURNING * ;
+		RETURNING ` + eventColumns + `;
 	`
 
 	var temp event
-	err = dataBase.QueryRow(statement, newEvent.UserId, newEvent.EventId).Scan(
-		&temp.EventId,
-		pq.Array(&temp.UserIds),
-		&temp.Size,
-		&temp.Description,
-		&temp.Name,
-		&temp.DateTime,
-		&temp.Owner)
+	err = scanEvent(dataBase.QueryRow(statement, newEvent.UserId, newEvent.EventId), &temp)
 
 	if err != nil {
 		log.Fatal(err)
 		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
 	}
 
+	// joining a series also joins every occurrence that has its own participant list,
+	// unless that occurrence is already full
+	_, err = dataBase.Exec(`
+		UPDATE event_occurrences
+		SET user_id = array_append(user_id, $1)
+		WHERE event_id = $2 AND NOT $1 = ANY(user_id)
+		AND cardinality(user_id) < COALESCE(size, $3);
+	`, newEvent.UserId, newEvent.EventId, temp.Size)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newEvent}))
+		return
+	}
+
 	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
 }
 
@@ -1025,31 +1538,76 @@ func main() {
 	// HTTP router
 	router := gin.Default()
 	router.GET("/profiles", getProfiles)
-	router.POST("/profiles", addProfile)
+	router.POST("/profiles", requireUser("id"), addProfile)
 	router.POST("/register", register)
 	router.POST("/login", login)
-	router.PATCH("/profile", editProfile)
-	router.PUT("/tags", addTag)
-	router.POST("/tags", queryTag)
-	router.DELETE("/tags", deleteTag)
-	router.PUT("/updateGeog", updateGeog)
-	router.POST("/metNumber", metNumber)
-	router.POST("/matches", matches)
-	router.POST("/addInterest", addInterest)
-	router.POST("/addNotInterest", addNotInterest)
-	router.POST("/getMessage", getMessage)
-	router.POST("/sendMessage", sendMessage)
-	router.POST("/getChat", getChat)
-	router.POST("/setEvent", setEvent)
-	router.PATCH("/editEvent", editEvent)
-	router.DELETE("/removeEvent", removeEvent)
+	router.POST("/requestPasswordReset", requestPasswordReset)
+	router.POST("/confirmPasswordReset", confirmPasswordReset)
+	router.POST("/verifyEmail", verifyEmail)
+	router.POST("/resendVerification", resendVerification)
+	router.PATCH("/password", requireUser("id"), changePassword)
+	router.PATCH("/email", requireUser("id"), changeEmail)
+	router.POST("/2fa/enroll", requireUser("id"), enrollTwoFactor)
+	router.POST("/2fa/confirm", requireUser("id"), confirmTwoFactor)
+	router.POST("/2fa/disable", requireUser("id"), disableTwoFactor)
+	router.POST("/login/2fa", loginTwoFactor)
+	router.POST("/login/oidc", loginOidc)
+	router.POST("/getOnboarding", requireUser("id"), getOnboardingStatus)
+	router.POST("/exportAccount", requireUser("id"), exportAccount)
+	router.POST("/deleteAccount", requireUser("id"), requestAccountDeletion)
+	router.POST("/cancelAccountDeletion", requireUser("id"), cancelAccountDeletion)
+	router.GET("/sessions", requireSession(), getSessions)
+	router.DELETE("/sessions/:id", requireSession(), revokeSession)
+	router.POST("/logout", requireSession(), logout)
+	router.POST("/logoutAll", requireSession(), logoutAll)
+	router.PATCH("/profile", requireUser("id"), editProfile)
+	router.PUT("/tags", requireUser("id"), addTag)
+	router.POST("/tags", requireSession(), queryTag)
+	router.DELETE("/tags", requireUser("id"), deleteTag)
+	router.PUT("/updateGeog", requireUser("id"), updateGeog)
+	router.POST("/metNumber", requireUser("id"), metNumber)
+	router.POST("/matches", requireUser("id"), requireOnboarded(), matches)
+	router.POST("/addInterest", requireUser("id_from"), addInterest)
+	router.POST("/addNotInterest", requireUser("id_from"), addNotInterest)
+	router.POST("/getMessage", requireUser("id_from"), requireOnboarded(), getMessage)
+	router.POST("/sendMessage", requireUser("id_from"), requireOnboarded(), sendMessage)
+	router.PATCH("/editMessage", requireUser("id_from"), requireOnboarded(), editMessage)
+	router.DELETE("/deleteMessage", requireUser("id_from"), deleteMessage)
+	router.POST("/getMessageEdit", requireUser("id_from"), getMessageEdit)
+	router.POST("/searchMessage", requireUser("id"), requireOnboarded(), searchMessage)
+	router.POST("/exportConversation", requireUser("id"), exportConversation)
+	router.POST("/attachments", requireSession(), uploadAttachment)
+	router.GET("/attachments/:id", requireSession(), getAttachment)
+	router.POST("/getChat", requireUser("id"), requireOnboarded(), getChat)
+	router.POST("/getConversation", requireUser("id"), requireOnboarded(), getConversation)
+	router.POST("/markConversationRead", requireUser("id"), requireOnboarded(), markConversationRead)
+	router.POST("/setEvent", requireSession(), setEvent)
+	router.PATCH("/editEvent", requireSession(), editEvent)
+	router.DELETE("/removeEvent", requireSession(), removeEvent)
+	router.DELETE("/admin/event", requireUser("admin_id"), adminRemoveEvent)
+	router.POST("/admin/moderation", requireUser("admin_id"), getModerationQueue)
+	router.PATCH("/admin/moderation", requireUser("admin_id"), reviewModerationItem)
 	router.GET("/getEvent", getEvent)
-	router.POST("/addIdToEvent", addToEvent)
-	router.POST("/removeIdFromEvent", removeIdFromEvent)
-	router.POST("/getJoinedEvent", getJoinedEvent)
-	router.POST("/reported", addReport)
-	router.DELETE("/reported", removeReport)
-	router.POST("/checkReported", checkReported)
+	router.POST("/addIdToEvent", requireUser("id"), addToEvent)
+	router.POST("/removeIdFromEvent", requireUser("user_id"), removeIdFromEvent)
+	router.POST("/getJoinedEvent", requireUser("id"), getJoinedEvent)
+	router.POST("/reported", requireUser("id_from"), addReport)
+	router.DELETE("/reported", requireUser("id_from"), removeReport)
+	router.POST("/checkReported", requireUser("id_from"), checkReported)
+	router.GET("/events/:id/ics", exportEvent)
+	router.POST("/calendarToken", requireSession(), getCalendarToken)
+	router.GET("/calendar/:token", calendarFeed)
+	router.POST("/getEventMessage", requireUser("id_from"), requireOnboarded(), getEventMessage)
+	router.POST("/sendEventMessage", requireUser("id_from"), requireOnboarded(), sendEventMessage)
+	router.GET("/ws", requireSocketSession(), requireOnboarded(), server.handleWs)
+	router.POST("/getNotification", requireUser("id"), getNotification)
+	router.POST("/deviceToken", requireUser("id"), registerDevice)
+	router.DELETE("/deviceToken", requireUser("id"), unregisterDevice)
+	router.POST("/getNotificationPrefs", requireUser("id"), getNotificationPrefs)
+	router.PUT("/notificationPrefs", requireUser("id"), editNotificationPrefs)
+
+	// background jobs
+	startJobs()
 
 	router.Run(":8080")
 }
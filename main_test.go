@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -37,6 +38,66 @@ func setupRouter() *gin.Engine {
 	return router
 }
 
+// skip a test that needs the database when it cannot be reached
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if err := dataBase.Ping(); err != nil {
+		t.Skip("database unavailable: ", err)
+	}
+}
+
+// send a JSON request to the router
+func performRequest(router *gin.Engine, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	jsonValue, _ := json.Marshal(body)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// send a JSON request with a session for the user
+func performRequestAs(t *testing.T, router *gin.Engine, method string, path string, userId string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	token := "test-session-" + userId
+	sessions.put(hashToken(token), session{Id: userId}, time.Now())
+	t.Cleanup(func() { sessions.remove(hashToken(token)) })
+
+	jsonValue, _ := json.Marshal(body)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// create an account for a test, it is deleted with everything tied to it when the test ends
+func createTestUser(t *testing.T, email string) string {
+	t.Helper()
+
+	var userId string
+	salt := generateSalt()
+	err := dataBase.QueryRow(`
+		INSERT INTO auth (email, salt, pwd, verified)
+		VALUES ($1, $2, $3, true)
+		RETURNING id;
+	`, email, salt, hashPassword("password", salt)).Scan(&userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := deleteAccount(context.Background(), userId); err != nil {
+			t.Error(err)
+		}
+	})
+	return userId
+}
+
 func TestGetProfiles(t *testing.T) {
 	router := setupRouter()
 
@@ -64,6 +125,37 @@ func TestLogin(t *testing.T) {
 	assert.Equal(t, http.StatusFound, w.Code)
 }
 
+func TestRegisterTooManyTags(t *testing.T) {
+	router := setupRouter()
+
+	request := registerRequest{
+		auth:    auth{Email: "testtes123@abc.com", Pwd: "asdfghj"},
+		Profile: &profile{Name: "Test", Age: 20},
+		Tags:    []string{"a", "b", "c", "d", "e", "f", "g", "a"},
+	}
+	jsonValue, _ := json.Marshal(request)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUniqueTags(t *testing.T) {
+	tags, ok := uniqueTags([]string{"hiking", "music", "hiking"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"hiking", "music"}, tags)
+
+	tags, ok = uniqueTags(nil)
+	assert.True(t, ok)
+	assert.Empty(t, tags)
+
+	_, ok = uniqueTags([]string{"a", "b", "c", "d", "e", "f", "g"})
+	assert.False(t, ok)
+}
+
 func TestEditProfile(t *testing.T) {
 	router := setupRouter()
 
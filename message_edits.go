@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long after sending a message its sender may still edit it
+const messageEditWindow = 15 * time.Minute
+
+type messageEdit struct {
+	MsgId    int       `json:"msg_id"`
+	Msg      string    `json:"msg"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+// load a message the given user sent
+func loadOwnMessage(msgId int, userId string) (message, int, string) {
+	var ret message
+	err := scanMessage(dataBase.QueryRow(`SELECT `+messageColumns+` FROM messaage WHERE msg_id = $1;`, msgId), &ret)
+
+	if err == sql.ErrNoRows {
+		return ret, http.StatusNotFound, "message not found"
+	} else if err != nil {
+		return ret, http.StatusOK, err.Error()
+	}
+
+	if ret.IdFrom != userId {
+		return ret, http.StatusForbidden, "not the sender"
+	}
+	if ret.DeletedAt != nil {
+		return ret, http.StatusGone, "message deleted"
+	}
+	return ret, http.StatusOK, ""
+}
+
+// change the text of a message, the previous text is kept in message_edits
+func editMessage(context *gin.Context) {
+	var newMessage message
+
+	if err := context.BindJSON(&newMessage); err != nil {
+		return
+	}
+
+	current, status, errMsg := loadOwnMessage(newMessage.MsgId, newMessage.IdFrom)
+	if errMsg != "" {
+		context.IndentedJSON(status, newResponse(errMsg, []interface{}{newMessage}))
+		return
+	}
+
+	if time.Since(current.TimeSent) > messageEditWindow {
+		context.IndentedJSON(http.StatusForbidden, newResponse("edit window passed", []interface{}{newMessage}))
+		return
+	}
+
+	// the new text goes through the same checks as a new message
+	flagged, ok := filterContent(context, &newMessage.Msg, newMessage)
+	if !ok {
+		return
+	}
+
+	refused, err := checkFlood(message{IdFrom: current.IdFrom, IdTo: current.IdTo, Msg: newMessage.Msg})
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	if refused != nil {
+		respondRefused(context, refused)
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO message_edits (msg_id, msg, edited_at)
+		VALUES ($1, $2, current_timestamp);
+	`, current.MsgId, current.Msg)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	var temp message
+	err = scanMessage(tx.QueryRow(`
+		UPDATE messaage SET msg = $2, edited_at = current_timestamp
+		WHERE msg_id = $1
+		RETURNING `+messageColumns+`;
+	`, current.MsgId, newMessage.Msg), &temp)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	flagForReview("message", strconv.Itoa(temp.MsgId), temp.IdFrom, temp.Msg, flagged)
+
+	server.send(temp.IdTo, "message_edited", temp)
+	server.send(temp.IdFrom, "message_edited", temp)
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
+}
+
+// unsend a message for both sides, the row stays as a tombstone so pagination does not shift
+// while its attachments are removed
+func deleteMessage(context *gin.Context) {
+	var newMessage message
+
+	if err := context.BindJSON(&newMessage); err != nil {
+		return
+	}
+
+	current, status, errMsg := loadOwnMessage(newMessage.MsgId, newMessage.IdFrom)
+	if errMsg != "" {
+		context.IndentedJSON(status, newResponse(errMsg, []interface{}{newMessage}))
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	defer tx.Rollback()
+
+	// earlier versions go too
+	_, err = tx.Exec(`DELETE FROM message_edits WHERE msg_id = $1;`, current.MsgId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	keys, err := deleteAttachments(tx, current.MsgId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	var temp message
+	err = scanMessage(tx.QueryRow(`
+		UPDATE messaage SET msg = '', deleted_at = current_timestamp
+		WHERE msg_id = $1
+		RETURNING `+messageColumns+`;
+	`, current.MsgId), &temp)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newMessage}))
+		return
+	}
+	deleteBlobs(keys)
+
+	server.send(temp.IdTo, "message_deleted", temp)
+	server.send(temp.IdFrom, "message_deleted", temp)
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{temp}))
+}
+
+// earlier versions of a message, oldest first, for either side of the conversation
+func getMessageEdit(context *gin.Context) {
+	var request message
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	rows, err := dataBase.Query(`
+		SELECT message_edits.msg_id, message_edits.msg, message_edits.edited_at
+		FROM message_edits
+		JOIN messaage ON messaage.msg_id = message_edits.msg_id
+		WHERE message_edits.msg_id = $1
+		AND (messaage.id_from = $2 OR messaage.id_to = $2)
+		ORDER BY message_edits.edited_at;
+	`, request.MsgId, request.IdFrom)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	defer rows.Close()
+
+	ret := []messageEdit{}
+	for rows.Next() {
+		temp := new(messageEdit)
+		rows.Scan(&temp.MsgId, &temp.Msg, &temp.EditedAt)
+		ret = append(ret, *temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
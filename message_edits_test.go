@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func messageEditRouter() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.PATCH("/editMessage", editMessage)
+	router.DELETE("/deleteMessage", deleteMessage)
+	router.POST("/getMessageEdit", getMessageEdit)
+	return router
+}
+
+func getMessageEdits(t *testing.T, router *gin.Engine, msgId int, userId string) []messageEdit {
+	t.Helper()
+
+	w := performRequest(router, http.MethodPost, "/getMessageEdit", message{MsgId: msgId, IdFrom: userId})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Body [][]messageEdit `json:"body"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body.Body[0]
+}
+
+func TestEditMessage(t *testing.T) {
+	requireDatabase(t)
+	router := messageEditRouter()
+
+	sender := createTestProfile(t, "edits-test-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "edits-test-recipient@example.com", "Recipient")
+	outsider := createTestProfile(t, "edits-test-outsider@example.com", "Outsider")
+	msgId := insertTestMessage(t, sender, recipient, "helo")
+
+	// only the sender edits
+	w := performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: recipient, Msg: "bye"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: -1, IdFrom: sender, Msg: "bye"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "hello"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "hello!"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Body []message `json:"body"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "hello!", body.Body[0].Msg)
+	assert.NotNil(t, body.Body[0].EditedAt)
+
+	// earlier versions, oldest first, for both sides only
+	for _, userId := range []string{sender, recipient} {
+		edits := getMessageEdits(t, router, msgId, userId)
+		if assert.Len(t, edits, 2) {
+			assert.Equal(t, "helo", edits[0].Msg)
+			assert.Equal(t, "hello", edits[1].Msg)
+		}
+	}
+	assert.Empty(t, getMessageEdits(t, router, msgId, outsider))
+}
+
+func TestEditMessageWindow(t *testing.T) {
+	requireDatabase(t)
+	router := messageEditRouter()
+
+	sender := createTestProfile(t, "edits-test-late-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "edits-test-late-recipient@example.com", "Recipient")
+	msgId := insertTestMessage(t, sender, recipient, "hi")
+	_, err := dataBase.Exec(`UPDATE messaage SET time_sent = time_sent - interval '16 minutes' WHERE msg_id = $1;`, msgId)
+	assert.NoError(t, err)
+
+	w := performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "hey"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, getMessageEdits(t, router, msgId, sender))
+
+	// deleting has no window
+	w = performRequest(router, http.MethodDelete, "/deleteMessage", message{MsgId: msgId, IdFrom: sender})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	requireDatabase(t)
+	router := messageEditRouter()
+
+	sender := createTestProfile(t, "edits-test-unsend-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "edits-test-unsend-recipient@example.com", "Recipient")
+	msgId := insertTestMessage(t, sender, recipient, "oops")
+
+	w := performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "oops!"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = performRequest(router, http.MethodDelete, "/deleteMessage", message{MsgId: msgId, IdFrom: recipient})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = performRequest(router, http.MethodDelete, "/deleteMessage", message{MsgId: msgId, IdFrom: sender})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// a tombstone stays, without its text or history
+	var m message
+	assert.NoError(t, scanMessage(dataBase.QueryRow(`SELECT `+messageColumns+` FROM messaage WHERE msg_id = $1;`, msgId), &m))
+	assert.Equal(t, "", m.Msg)
+	assert.NotNil(t, m.DeletedAt)
+	assert.Empty(t, getMessageEdits(t, router, msgId, sender))
+
+	// and it can be neither edited nor deleted again
+	w = performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "back"})
+	assert.Equal(t, http.StatusGone, w.Code)
+	w = performRequest(router, http.MethodDelete, "/deleteMessage", message{MsgId: msgId, IdFrom: sender})
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+func TestEditMessageChecks(t *testing.T) {
+	requireDatabase(t)
+	router := messageEditRouter()
+
+	sender := createTestProfile(t, "edits-test-checks-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "edits-test-checks-recipient@example.com", "Recipient")
+	msgId := insertTestMessage(t, sender, recipient, "hi")
+	insertTestMessage(t, sender, recipient, "see you there")
+
+	// edits are filtered like new messages
+	w := performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "call +65 9123 4567"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Body []message `json:"body"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "call *************", body.Body[0].Msg)
+
+	// and cannot repeat what was just sent
+	w = performRequest(router, http.MethodPatch, "/editMessage", message{MsgId: msgId, IdFrom: sender, Msg: "see you there"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, getMessageEdits(t, router, msgId, sender), 1)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type notification struct {
+	NotificationId int       `json:"notification_id"`
+	Id             string    `json:"id"`
+	Kind           string    `json:"kind"`
+	Title          string    `json:"title"`
+	Msg            string    `json:"msg"`
+	TimeSent       time.Time `json:"time_sent"`
+}
+
+// store a notification for a user and push it to their open connections, or to their devices when offline
+func notify(userId string, kind string, title string, msg string) error {
+	statement := `
+		INSERT INTO notifications (id, kind, title, msg, time_sent)
+		VALUES ($1, $2, $3, $4, current_timestamp)
+		RETURNING notification_id, id, kind, title, msg, time_sent;
+	`
+
+	var temp notification
+	err := dataBase.QueryRow(statement, userId, kind, title, msg).Scan(&temp.NotificationId, &temp.Id, &temp.Kind, &temp.Title, &temp.Msg, &temp.TimeSent)
+	if err != nil {
+		return err
+	}
+
+	if !server.send(userId, "notification", temp) {
+		go push(userId, pushNotification{Kind: kind, Title: title, Body: msg})
+	}
+	return nil
+}
+
+// send to every user, one failure does not stop the rest. returns how many failed.
+func sendEach(userIds []int64, send func(userId string) error) int {
+	failed := 0
+	for _, userId := range userIds {
+		if err := send(strconv.FormatInt(userId, 10)); err != nil {
+			log.Println(err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// notify everyone taking part in an event, a failure for one member is logged and the rest are still notified
+func notifyEventMembers(eventId string, kind string, title string, msg string) error {
+	members, err := eventMembers(eventId)
+	if err != nil {
+		return err
+	}
+
+	sendEach(members, func(userId string) error {
+		return notify(userId, kind, title, msg)
+	})
+	return nil
+}
+
+// the 20 latest notifications of a user sent before time_sent
+func getNotification(context *gin.Context) {
+	var request notification
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if request.TimeSent.IsZero() {
+		request.TimeSent = time.Now()
+	}
+
+	rows, err := dataBase.Query(`
+		SELECT notification_id, id, kind, title, msg, time_sent FROM notifications
+		WHERE id = $1 AND time_sent < $2
+		ORDER BY time_sent DESC
+		LIMIT 20;
+	`, request.Id, request.TimeSent)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+	defer rows.Close()
+
+	ret := []notification{}
+	for rows.Next() {
+		temp := new(notification)
+		rows.Scan(&temp.NotificationId, &temp.Id, &temp.Kind, &temp.Title, &temp.Msg, &temp.TimeSent)
+		ret = append(ret, *temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
+
+// tell a user they matched with peer
+func notifyMatch(userId string, peerId string) {
+	var name string
+	if err := dataBase.QueryRow(`SELECT name FROM profile WHERE id = $1;`, peerId).Scan(&name); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := notify(userId, "match", "New match", "You matched with "+name); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func countNotifications(t *testing.T, userId string) int {
+	t.Helper()
+
+	var count int
+	if err := dataBase.QueryRow(`SELECT count(*) FROM notifications WHERE id = $1;`, userId).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestNotifyEventMembers(t *testing.T) {
+	requireDatabase(t)
+
+	owner := createTestUser(t, "notifications-test-member-owner@example.com")
+	member := createTestUser(t, "notifications-test-member@example.com")
+	t.Cleanup(func() { dataBase.Exec(`DELETE FROM notifications WHERE id IN ($1, $2);`, owner, member) })
+	eventId := createTestEvent(t, owner, "{"+owner+","+member+"}")
+
+	assert.NoError(t, notifyEventMembers(eventId, "event_updated", "Test event", "Test event has been updated"))
+	assert.Equal(t, 1, countNotifications(t, owner))
+	assert.Equal(t, 1, countNotifications(t, member))
+}
+
+func TestSetEventDoesNotNotify(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/setEvent", requireSession(), setEvent)
+
+	owner := createTestUser(t, "notifications-test-owner@example.com")
+	ownerId, _ := strconv.ParseInt(owner, 10, 64)
+	w := performRequestAs(t, router, http.MethodPost, "/setEvent", owner, event{
+		UserIds:  []int64{ownerId},
+		Size:     4,
+		Name:     "Picnic",
+		DateTime: time.Now().Add(24 * time.Hour),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	t.Cleanup(func() { dataBase.Exec(`DELETE FROM events WHERE owner = $1;`, owner) })
+
+	// nobody but the creator is in a new event, there is nothing to tell
+	assert.Equal(t, 0, countNotifications(t, owner))
+}
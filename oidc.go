@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// how long fetched signing keys are used before fetching them again
+	jwksTTL = time.Hour
+	// an unknown key id refetches the keys at most this often, in case the provider rotated them
+	jwksRefetchInterval = time.Minute
+	// allowed clock difference with the provider
+	idTokenLeeway = time.Minute
+)
+
+// an OpenID Connect provider users can sign in with, loaded from OIDC_CONFIG
+type oidcProvider struct {
+	Name     string `json:"name"`
+	Issuer   string `json:"issuer"`
+	ClientId string `json:"client_id"`
+	// found through the issuer's discovery document when empty
+	JwksUrl string `json:"jwks_url,omitempty"`
+
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type oidcLoginRequest struct {
+	Provider string `json:"provider"`
+	IdToken  string `json:"id_token"`
+	Nonce    string `json:"nonce,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// providers send email_verified as a boolean or as a string
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	*b = flexBool(value)
+	return err
+}
+
+// aud is a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*a = list
+	return err
+}
+
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var oidcProviders = map[string]*oidcProvider{}
+
+func init() {
+	path := os.Getenv("OIDC_CONFIG")
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var providers []*oidcProvider
+	if err = json.Unmarshal(data, &providers); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range providers {
+		p.client = &http.Client{Timeout: 10 * time.Second}
+		oidcProviders[p.Name] = p
+	}
+}
+
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	response, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// fetch the provider's signing keys, finding the JWKS url through discovery the first time
+func (p *oidcProvider) fetchKeys() error {
+	if p.JwksUrl == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JwksUri string `json:"jwks_uri"`
+		}
+		if err := p.getJSON(strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return err
+		}
+		if discovery.Issuer != p.Issuer || discovery.JwksUri == "" {
+			return fmt.Errorf("oidc provider %s: bad discovery document", p.Name)
+		}
+		p.JwksUrl = discovery.JwksUri
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(p.JwksUrl, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.keys = keys
+	p.fetchedAt = time.Now()
+	return nil
+}
+
+// the signing key with the given id, keys are refetched when stale or when the id is unknown
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := time.Since(p.fetchedAt)
+	if key, ok := p.keys[kid]; ok && age < jwksTTL {
+		return key, nil
+	}
+	if p.keys == nil || age >= jwksRefetchInterval {
+		if err := p.fetchKeys(); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// check an ID token's RS256 signature and claims, returns its claims
+func (p *oidcProvider) verify(token string, nonce string, now time.Time) (idTokenClaims, error) {
+	var claims idTokenClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed id token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	// only RS256, never "none" or a symmetric algorithm keyed with the public key
+	if header.Alg != "RS256" {
+		return claims, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return claims, errors.New("invalid id token signature")
+	}
+
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return claims, errors.New("id token from another issuer")
+	case !claims.Audience.contains(p.ClientId):
+		return claims, errors.New("id token for another client")
+	case now.After(time.Unix(claims.Expiry, 0).Add(idTokenLeeway)):
+		return claims, errors.New("id token expired")
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenLeeway)):
+		return claims, errors.New("id token issued in the future")
+	case nonce != "" && claims.Nonce != nonce:
+		return claims, errors.New("id token nonce mismatch")
+	case claims.Subject == "":
+		return claims, errors.New("id token without subject")
+	}
+	return claims, nil
+}
+
+func (a audience) contains(clientId string) bool {
+	for _, aud := range a {
+		if aud == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// the account an identity belongs to, linking or creating one the first time it is seen.
+// returns whether the account was created.
+func identityAccount(provider string, claims idTokenClaims) (int, bool, error) {
+	tx, err := dataBase.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var userId int
+	err = tx.QueryRow(`SELECT id FROM identities WHERE provider = $1 AND subject = $2;`, provider, claims.Subject).Scan(&userId)
+	if err == nil {
+		return userId, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	if claims.Email == "" {
+		return 0, false, errors.New("id token without email")
+	}
+
+	created := false
+	err = tx.QueryRow(`SELECT id FROM auth WHERE email = $1;`, claims.Email).Scan(&userId)
+	if err == nil {
+		// only an address the provider checked may take over an existing account
+		if !claims.EmailVerified {
+			return 0, false, errEmailUnverified
+		}
+		if _, err = tx.Exec(`UPDATE auth SET verified = true WHERE id = $1;`, userId); err != nil {
+			return 0, false, err
+		}
+	} else if err == sql.ErrNoRows {
+		// no password works for an account made this way, it can be set with a password reset
+		err = tx.QueryRow(`
+			INSERT INTO auth (email, salt, pwd, verified)
+			VALUES ($1, $2, '', $3)
+			RETURNING id;
+		`, claims.Email, generateSalt(), bool(claims.EmailVerified)).Scan(&userId)
+		if err != nil {
+			return 0, false, err
+		}
+		created = true
+	} else {
+		return 0, false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO identities (provider, subject, id, email, created_at)
+		VALUES ($1, $2, $3, $4, current_timestamp);
+	`, provider, claims.Subject, userId, claims.Email)
+	if err != nil {
+		return 0, false, err
+	}
+	return userId, created, tx.Commit()
+}
+
+var errEmailUnverified = errors.New("email exists, log in with your password")
+
+// log in with an ID token from an OpenID Connect provider, new users get an account
+func loginOidc(context *gin.Context) {
+	var request oidcLoginRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	provider, ok := oidcProviders[request.Provider]
+	if !ok {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("unknown provider", []interface{}{}))
+		return
+	}
+
+	claims, err := provider.verify(request.IdToken, request.Nonce, time.Now())
+	if err != nil {
+		context.IndentedJSON(http.StatusUnauthorized, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	userId, created, err := identityAccount(provider.Name, claims)
+	if err == errEmailUnverified {
+		context.IndentedJSON(http.StatusConflict, newResponse(err.Error(), []interface{}{}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	// new accounts have no profile yet, like after register
+	if created {
+		device := request.Device
+		if device == "" {
+			device = context.Request.UserAgent()
+		}
+		token, err := createSession(userId, device, context.ClientIP())
+		if err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+			return
+		}
+		context.IndentedJSON(http.StatusCreated, newResponse("ok", []interface{}{id{strconv.Itoa(userId)}, sessionToken{token}}))
+		return
+	}
+
+	// the provider stands in for the password, a second factor is still asked for
+	challenge, err := startTwoFactor(userId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	if challenge != "" {
+		context.IndentedJSON(http.StatusAccepted, newResponse("2fa required", []interface{}{twoFactorChallenge{Challenge: challenge}}))
+		return
+	}
+
+	completeLogin(context, userId, request.Device)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a stand-in OpenID Connect provider serving discovery and its signing keys
+type testIssuer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	issuer := &testIssuer{keys: map[string]*rsa.PrivateKey{}}
+	issuer.addKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.fetches++
+
+		keys := []jsonWebKey{}
+		for kid, key := range issuer.keys {
+			keys = append(keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (issuer *testIssuer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	issuer.mu.Lock()
+	issuer.keys[kid] = key
+	issuer.mu.Unlock()
+}
+
+func (issuer *testIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := segment(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	issuer.mu.Lock()
+	signature, err := rsa.SignPKCS1v15(rand.Reader, issuer.keys[kid], crypto.SHA256, digest[:])
+	issuer.mu.Unlock()
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (issuer *testIssuer) provider() *oidcProvider {
+	return &oidcProvider{Name: "test", Issuer: issuer.URL, ClientId: "hotandcold", client: issuer.Client()}
+}
+
+func (issuer *testIssuer) claims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            issuer.URL,
+		"sub":            "subject-1",
+		"aud":            "hotandcold",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          "n-0S6_WzA2Mj",
+		"email":          "someone@example.com",
+		"email_verified": true,
+	}
+}
+
+func TestOidcVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	now := time.Now()
+
+	claims, err := provider.verify(issuer.sign(t, "key-1", issuer.claims(now)), "n-0S6_WzA2Mj", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "subject-1", claims.Subject)
+	assert.Equal(t, "someone@example.com", claims.Email)
+	assert.True(t, bool(claims.EmailVerified))
+	assert.Equal(t, issuer.URL+"/jwks", provider.JwksUrl)
+
+	// aud as a list, email_verified as a string
+	c := issuer.claims(now)
+	c["aud"] = []string{"another", "hotandcold"}
+	c["email_verified"] = "false"
+	claims, err = provider.verify(issuer.sign(t, "key-1", c), "", now)
+	assert.NoError(t, err)
+	assert.False(t, bool(claims.EmailVerified))
+
+	rejected := map[string]func(map[string]interface{}){
+		"another client": func(c map[string]interface{}) { c["aud"] = "another" },
+		"another issuer": func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"future":         func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() },
+		"nonce":          func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, change := range rejected {
+		c := issuer.claims(now)
+		change(c)
+		_, err = provider.verify(issuer.sign(t, "key-1", c), "n-0S6_WzA2Mj", now)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestOidcVerifySignature(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	now := time.Now()
+	token := issuer.sign(t, "key-1", issuer.claims(now))
+	parts := strings.Split(token, ".")
+
+	// claims changed after signing
+	c := issuer.claims(now)
+	c["sub"] = "someone-else"
+	forged := strings.Split(issuer.sign(t, "key-1", c), ".")
+	_, err := provider.verify(parts[0]+"."+forged[1]+"."+parts[2], "", now)
+	assert.EqualError(t, err, "invalid id token signature")
+
+	// unsigned
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`))
+	_, err = provider.verify(none+"."+parts[1]+".", "", now)
+	assert.Error(t, err)
+
+	_, err = provider.verify("not a token", "", now)
+	assert.Error(t, err)
+}
+
+func TestOidcKeyRotation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	now := time.Now()
+
+	_, err := provider.verify(issuer.sign(t, "key-1", issuer.claims(now)), "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, issuer.fetches)
+
+	// a new key is not looked for again straight away
+	issuer.addKey(t, "key-2")
+	_, err = provider.verify(issuer.sign(t, "key-2", issuer.claims(now)), "", now)
+	assert.EqualError(t, err, "unknown signing key")
+	assert.Equal(t, 1, issuer.fetches)
+
+	provider.fetchedAt = provider.fetchedAt.Add(-jwksRefetchInterval)
+	_, err = provider.verify(issuer.sign(t, "key-2", issuer.claims(now)), "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, issuer.fetches)
+
+	// known keys are used from the cache
+	_, err = provider.verify(issuer.sign(t, "key-1", issuer.claims(now)), "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, issuer.fetches)
+}
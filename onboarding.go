@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// onboarding steps, in the order new users go through them
+const (
+	stepRegister = "register"
+	stepProfile  = "profile"
+	stepTags     = "tags"
+	stepLocation = "location"
+	// every step is done
+	stepDone = "done"
+)
+
+var onboardingSteps = []string{stepRegister, stepProfile, stepTags, stepLocation}
+
+// where a user is in onboarding, worked out from what they have filled in until they
+// finish. finishing is recorded, as locations are purged after a day.
+type onboardingStatus struct {
+	Id string `json:"id"`
+	// the first step not done yet, or "done"
+	Step      string   `json:"step"`
+	Completed []string `json:"completed"`
+	// required fields not filled in yet: name, age, tags and location
+	Missing  []string `json:"missing"`
+	Complete bool     `json:"complete"`
+}
+
+// what onboarding status is worked out from
+type onboardingData struct {
+	HasProfile  bool
+	Name        string
+	Age         int
+	Tags        int
+	HasLocation bool
+	// onboarding was finished before
+	Onboarded bool
+}
+
+func newOnboardingStatus(userId string, data onboardingData) onboardingStatus {
+	status := onboardingStatus{Id: userId, Completed: []string{}, Missing: []string{}}
+
+	if data.Onboarded {
+		status.Step = stepDone
+		status.Completed = onboardingSteps
+		status.Complete = true
+		return status
+	}
+
+	if data.Name == "" {
+		status.Missing = append(status.Missing, "name")
+	}
+	if data.Age <= 0 {
+		status.Missing = append(status.Missing, "age")
+	}
+	if data.Tags == 0 {
+		status.Missing = append(status.Missing, "tags")
+	}
+	if !data.HasLocation {
+		status.Missing = append(status.Missing, "location")
+	}
+
+	done := map[string]bool{
+		stepRegister: true,
+		stepProfile:  data.HasProfile && data.Name != "" && data.Age > 0,
+		stepTags:     data.Tags > 0,
+		stepLocation: data.HasLocation,
+	}
+	status.Step = stepDone
+	for _, step := range onboardingSteps {
+		if done[step] {
+			status.Completed = append(status.Completed, step)
+		} else if status.Step == stepDone {
+			status.Step = step
+		}
+	}
+	status.Complete = status.Step == stepDone
+	return status
+}
+
+func loadOnboardingStatus(userId string) (onboardingStatus, error) {
+	var data onboardingData
+	var name *string
+	var age *int
+
+	// each table's id has its own parameter, as the columns' types differ
+	err := dataBase.QueryRow(`
+		SELECT
+			(SELECT name FROM profile WHERE id = $1),
+			(SELECT age FROM profile WHERE id = $1),
+			(SELECT count(*) FROM tags WHERE id = $2),
+			EXISTS (SELECT 1 FROM geog WHERE id = $3),
+			EXISTS (SELECT 1 FROM auth WHERE id = $4 AND onboarded_at IS NOT NULL);
+	`, userId, userId, userId, userId).Scan(&name, &age, &data.Tags, &data.HasLocation, &data.Onboarded)
+	if err != nil {
+		return onboardingStatus{}, err
+	}
+
+	if name != nil {
+		data.HasProfile = true
+		data.Name = *name
+	}
+	if age != nil {
+		data.Age = *age
+	}
+
+	status := newOnboardingStatus(userId, data)
+	if status.Complete && !data.Onboarded {
+		_, err = dataBase.Exec(`UPDATE auth SET onboarded_at = current_timestamp WHERE id = $1 AND onboarded_at IS NULL;`, userId)
+	}
+	return status, err
+}
+
+// record finishing onboarding once a step is done, so it is kept when the location is purged
+func recordOnboarding(userId string) {
+	if _, err := loadOnboardingStatus(userId); err != nil {
+		log.Println(err)
+	}
+}
+
+func getOnboardingStatus(context *gin.Context) {
+	var newId id
+
+	if err := context.BindJSON(&newId); err != nil {
+		return
+	}
+
+	status, err := loadOnboardingStatus(newId.Id)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newId}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{status}))
+}
+
+// refuse requests from users who have not finished onboarding. the user is the logged
+// in one, so this goes after requireSession or requireUser.
+func requireOnboarded() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		value, ok := context.Get("session")
+		if !ok {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, newResponse("session required", []interface{}{}))
+			return
+		}
+
+		status, err := loadOnboardingStatus(value.(session).Id)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+			return
+		}
+		if !status.Complete {
+			context.AbortWithStatusJSON(http.StatusForbidden, newResponse("onboarding incomplete", []interface{}{status}))
+			return
+		}
+
+		context.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOnboardingStatus(t *testing.T) {
+	status := newOnboardingStatus("1", onboardingData{})
+	assert.Equal(t, stepProfile, status.Step)
+	assert.Equal(t, []string{stepRegister}, status.Completed)
+	assert.Equal(t, []string{"name", "age", "tags", "location"}, status.Missing)
+	assert.False(t, status.Complete)
+
+	// a profile without an age is not done
+	status = newOnboardingStatus("1", onboardingData{HasProfile: true, Name: "Jane", Tags: 2})
+	assert.Equal(t, stepProfile, status.Step)
+	assert.Equal(t, []string{stepRegister, stepTags}, status.Completed)
+	assert.Equal(t, []string{"age", "location"}, status.Missing)
+
+	status = newOnboardingStatus("1", onboardingData{HasProfile: true, Name: "Jane", Age: 28, Tags: 2})
+	assert.Equal(t, stepLocation, status.Step)
+	assert.Equal(t, []string{"location"}, status.Missing)
+
+	status = newOnboardingStatus("1", onboardingData{HasProfile: true, Name: "Jane", Age: 28, Tags: 2, HasLocation: true})
+	assert.Equal(t, stepDone, status.Step)
+	assert.Equal(t, onboardingSteps, status.Completed)
+	assert.Empty(t, status.Missing)
+	assert.True(t, status.Complete)
+
+	// finishing is kept when the location is purged
+	status = newOnboardingStatus("1", onboardingData{HasProfile: true, Name: "Jane", Age: 28, Tags: 2, Onboarded: true})
+	assert.Equal(t, stepDone, status.Step)
+	assert.Empty(t, status.Missing)
+	assert.True(t, status.Complete)
+}
+
+func TestRequireOnboarded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/matches", requireUser("id"), requireOnboarded(), func(context *gin.Context) {
+		context.String(http.StatusOK, "ok")
+	})
+	post := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/matches", strings.NewReader(`{"id": "1"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("").Code)
+
+	requireDatabase(t)
+	userId := createTestProfile(t, "onboarding-test@example.com", "Jane")
+	sessions.put(hashToken("onboarding-test"), session{Id: userId}, time.Now())
+	defer sessions.remove(hashToken("onboarding-test"))
+
+	w := post("onboarding-test")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "onboarding incomplete")
+	assert.Contains(t, w.Body.String(), `"step": "tags"`)
+
+	_, err := dataBase.Exec(`INSERT INTO tags (id, tag) VALUES ($1, 'hiking');`, userId)
+	assert.NoError(t, err)
+	_, err = dataBase.Exec(`INSERT INTO geog (id, point, time) VALUES ($1, ST_SetSRID(ST_MakePoint(0, 0), 4326), current_timestamp);`, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, post("onboarding-test").Code)
+
+	// purging the location does not undo onboarding
+	_, err = dataBase.Exec(`DELETE FROM geog WHERE id = $1;`, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, post("onboarding-test").Code)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type resetRequest struct {
+	Email string `json:"email"`
+}
+
+type resetConfirm struct {
+	Token string `json:"token"`
+	Pwd   string `json:"pwd"`
+}
+
+// tokens sent to users are stored hashed
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// email a password reset token, the response is the same whether or not the email is registered
+func requestPasswordReset(context *gin.Context) {
+	var request resetRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	// every request sends an email, so each one counts against the address and the caller
+	keys := []string{"reset:" + accountKey(request.Email), "reset:" + ipKey(context.ClientIP())}
+	if throttled(context, loginThrottle, keys...) {
+		return
+	}
+	if err := loginThrottle.fail(time.Now(), keys...); err != nil {
+		log.Println(err)
+	}
+
+	var userId int
+	err := dataBase.QueryRow(`SELECT id FROM auth WHERE email = $1;`, request.Email).Scan(&userId)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	// only the latest token can be used
+	ttl := envDuration("PASSWORD_RESET_TTL", time.Hour)
+	_, err = dataBase.Exec(`
+		WITH cleared AS (
+			DELETE FROM password_resets WHERE id = $1
+		)
+		INSERT INTO password_resets (token_hash, id, expires_at)
+		VALUES ($2, $1, $3);
+	`, userId, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	body := "Someone asked to reset the password of your Hot & Cold account.\n\n" +
+		"Use this code to choose a new password, it expires in " + ttl.String() + ":\n\n" +
+		envOr("PASSWORD_RESET_URL", "") + token + "\n\n" +
+		"If this was not you, you can ignore this email.\n"
+	if err = mailer.Send(request.Email, "Reset your password", body); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+}
+
+// set a new password with a reset token, the token can only be used once
+func confirmPasswordReset(context *gin.Context) {
+	var request resetConfirm
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if request.Pwd == "" {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("pwd required", []interface{}{}))
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	defer tx.Rollback()
+
+	var userId int
+	err = tx.QueryRow(`
+		UPDATE password_resets SET used_at = current_timestamp
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > current_timestamp
+		RETURNING id;
+	`, hashToken(request.Token)).Scan(&userId)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid or expired token", []interface{}{}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	salt := generateSalt()
+	_, err = tx.Exec(`UPDATE auth SET salt = $1, pwd = $2 WHERE id = $3;`, salt, hashPassword(request.Pwd, salt), userId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	// whoever was signed in with the old password is signed out
+	if err = revokeSessions(tx, strconv.Itoa(userId)); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	server.disconnect(strconv.Itoa(userId))
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{}))
+}
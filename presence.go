@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+type chatProfile struct {
+	profile
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen"`
+}
+
+type typing struct {
+	Id     string `json:"id"`
+	PeerId string `json:"peer_id"`
+}
+
+type presence struct {
+	Id       string     `json:"id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen"`
+}
+
+func init() {
+	wsHandlers["typing_start"] = relayTyping("typing_start")
+	wsHandlers["typing_stop"] = relayTyping("typing_stop")
+}
+
+// typing events are not stored, they are passed on to the peer if the two are matched
+func relayTyping(eventType string) func(c *client, body json.RawMessage) {
+	return func(c *client, body json.RawMessage) {
+		var t typing
+		if err := json.Unmarshal(body, &t); err != nil {
+			return
+		}
+
+		t.Id = c.id
+		matched, err := isMutualMatch(t.Id, t.PeerId)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if matched {
+			server.send(t.PeerId, eventType, t)
+		}
+	}
+}
+
+// last time the user was seen, from closed connections or location updates
+func lastSeen(userId string, geogTime *time.Time) *time.Time {
+	ret := geogTime
+	if t, ok := server.seen(userId); ok && (ret == nil || t.After(*ret)) {
+		ret = &t
+	}
+	return ret
+}
+
+// tell the user's mutual matches that they came online or went offline
+func broadcastPresence(userId string) {
+	peers, err := mutualMatches(userId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	p := presence{Id: userId, Online: server.online(userId)}
+	if t, ok := server.seen(userId); ok {
+		p.LastSeen = &t
+	}
+	for _, peer := range peers {
+		server.send(peer, "presence", p)
+	}
+}
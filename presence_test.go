@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastSeen(t *testing.T) {
+	ts := startHub(t)
+	geogTime := time.Now().Add(-time.Hour)
+
+	// never connected, the last location update is all there is
+	assert.Nil(t, lastSeen("201", nil))
+	assert.Equal(t, &geogTime, lastSeen("201", &geogTime))
+
+	conn := dialHub(t, ts.URL, "201")
+	conn.Close()
+	for i := 0; i < 100 && server.online("201"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a closed connection is more recent than the location update
+	closed, ok := server.seen("201")
+	assert.True(t, ok)
+	assert.True(t, closed.After(geogTime))
+	assert.Equal(t, closed, *lastSeen("201", &geogTime))
+	assert.Equal(t, closed, *lastSeen("201", nil))
+
+	// and older than a later one
+	later := time.Now().Add(time.Hour)
+	assert.Equal(t, later, *lastSeen("201", &later))
+}
+
+func TestTypingRelay(t *testing.T) {
+	requireDatabase(t)
+	ts := startHub(t)
+
+	typist := createTestProfile(t, "presence-test-typist@example.com", "Typist")
+	peer := createTestProfile(t, "presence-test-peer@example.com", "Peer")
+	typistConn := dialHub(t, ts.URL, typist)
+	defer typistConn.Close()
+	peerConn := dialHub(t, ts.URL, peer)
+	defer peerConn.Close()
+
+	// not matched yet, nothing is passed on
+	relayTyping("typing_start")(&client{id: typist}, json.RawMessage(`{"peer_id": "`+peer+`"}`))
+
+	matchTestUsers(t, typist, peer)
+	body, _ := json.Marshal(typing{Id: peer, PeerId: peer})
+	assert.NoError(t, typistConn.WriteJSON(wsEvent{"typing_start", body}))
+
+	// the typist is the owner of the connection, whatever id the body has
+	var ev typing
+	assert.NoError(t, json.Unmarshal(readEvent(t, peerConn, "typing_start").Body, &ev))
+	assert.Equal(t, typing{Id: typist, PeerId: peer}, ev)
+
+	body, _ = json.Marshal(typing{PeerId: peer})
+	assert.NoError(t, typistConn.WriteJSON(wsEvent{"typing_stop", body}))
+	readEvent(t, peerConn, "typing_stop")
+}
+
+func TestBroadcastPresence(t *testing.T) {
+	requireDatabase(t)
+	ts := startHub(t)
+
+	user := createTestProfile(t, "presence-test-user@example.com", "User")
+	peer := createTestProfile(t, "presence-test-match@example.com", "Match")
+	matchTestUsers(t, user, peer)
+	peerConn := dialHub(t, ts.URL, peer)
+	defer peerConn.Close()
+
+	conn := dialHub(t, ts.URL, user)
+	var p presence
+	assert.NoError(t, json.Unmarshal(readEvent(t, peerConn, "presence").Body, &p))
+	assert.Equal(t, user, p.Id)
+	assert.True(t, p.Online)
+
+	conn.Close()
+	assert.NoError(t, json.Unmarshal(readEvent(t, peerConn, "presence").Body, &p))
+	assert.Equal(t, user, p.Id)
+	assert.False(t, p.Online)
+	assert.NotNil(t, p.LastSeen)
+}
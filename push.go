@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long a single push request may take
+const pushTimeout = 10 * time.Second
+
+// returned by a provider when the device token is no longer valid, the token is then dropped
+var errInvalidToken = errors.New("invalid device token")
+
+type device struct {
+	Id       string `json:"id"`
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+}
+
+type pushNotification struct {
+	Kind  string            `json:"kind"`
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// delivers notifications to a user's devices while they are offline
+type PushProvider interface {
+	Send(ctx context.Context, d device, n pushNotification) error
+}
+
+// push provider used by the server, chosen with PUSH_PROVIDER
+var pushProvider PushProvider = newPushProvider()
+
+func newPushProvider() PushProvider {
+	switch envOr("PUSH_PROVIDER", "log") {
+	case "http":
+		return httpPushProvider{
+			fcmUrl:    envOr("PUSH_FCM_URL", ""),
+			apnsUrl:   envOr("PUSH_APNS_URL", ""),
+			key:       envOr("PUSH_KEY", ""),
+			apnsTopic: envOr("PUSH_APNS_TOPIC", ""),
+			client:    &http.Client{Timeout: pushTimeout},
+		}
+	default:
+		return logPushProvider{}
+	}
+}
+
+// writes notifications to the log instead of sending them, for local development
+type logPushProvider struct{}
+
+func (logPushProvider) Send(ctx context.Context, d device, n pushNotification) error {
+	log.Printf("push to %s (%s): %s: %s", d.Id, d.Platform, n.Title, n.Body)
+	return nil
+}
+
+// sends to FCM for android devices and to APNs for ios devices
+type httpPushProvider struct {
+	fcmUrl    string
+	apnsUrl   string
+	key       string
+	apnsTopic string
+	client    *http.Client
+}
+
+func (p httpPushProvider) Send(ctx context.Context, d device, n pushNotification) error {
+	var endpoint string
+	var payload interface{}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Authorization", "Bearer "+p.key)
+
+	switch d.Platform {
+	case "fcm":
+		endpoint = p.fcmUrl
+		payload = map[string]interface{}{
+			"message": map[string]interface{}{
+				"token":        d.Token,
+				"notification": map[string]string{"title": n.Title, "body": n.Body},
+				"data":         n.Data,
+			},
+		}
+	case "apns":
+		endpoint = strings.TrimRight(p.apnsUrl, "/") + "/3/device/" + url.PathEscape(d.Token)
+		header.Set("apns-topic", p.apnsTopic)
+		header.Set("apns-push-type", "alert")
+		body := map[string]interface{}{
+			"aps": map[string]interface{}{
+				"alert": map[string]string{"title": n.Title, "body": n.Body},
+			},
+		}
+		for k, v := range n.Data {
+			if k != "aps" {
+				body[k] = v
+			}
+		}
+		payload = body
+	default:
+		return fmt.Errorf("unknown platform %q", d.Platform)
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header = header
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		// FCM answers 404 and APNs 410 for unregistered tokens
+		return errInvalidToken
+	case response.StatusCode >= 300:
+		return fmt.Errorf("push to %s failed: %s", d.Platform, response.Status)
+	}
+	return nil
+}
+
+type notificationPrefs struct {
+	Id         string `json:"id"`
+	Messages   bool   `json:"messages"`
+	Matches    bool   `json:"matches"`
+	Events     bool   `json:"events"`
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	UtcOffset  int    `json:"utc_offset"`
+}
+
+// every kind of push is on and there are no quiet hours until the user says otherwise
+func defaultNotificationPrefs(userId string) notificationPrefs {
+	return notificationPrefs{Id: userId, Messages: true, Matches: true, Events: true}
+}
+
+// whether the user wants to be pushed notifications of this kind
+func (p notificationPrefs) allows(kind string) bool {
+	switch {
+	case kind == "message":
+		return p.Messages
+	case kind == "match":
+		return p.Matches
+	case strings.HasPrefix(kind, "event_"):
+		return p.Events
+	}
+	return true
+}
+
+// parse a HH:MM clock time into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// whether now falls in the user's quiet hours, which may span midnight
+func (p notificationPrefs) quiet(now time.Time) bool {
+	if p.QuietStart == "" || p.QuietEnd == "" {
+		return false
+	}
+	start, err := parseClock(p.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(p.QuietEnd)
+	if err != nil {
+		return false
+	}
+
+	local := now.UTC().Add(time.Duration(p.UtcOffset) * time.Minute)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func loadNotificationPrefs(userId string) (notificationPrefs, error) {
+	prefs := defaultNotificationPrefs(userId)
+	err := dataBase.QueryRow(`
+		SELECT messages, matches, events, quiet_start, quiet_end, utc_offset
+		FROM notification_prefs WHERE id = $1;
+	`, userId).Scan(&prefs.Messages, &prefs.Matches, &prefs.Events, &prefs.QuietStart, &prefs.QuietEnd, &prefs.UtcOffset)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	return prefs, err
+}
+
+func userDevices(userId string) ([]device, error) {
+	rows, err := dataBase.Query(`SELECT id, token, platform FROM device_tokens WHERE id = $1;`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []device
+	for rows.Next() {
+		var d device
+		if err = rows.Scan(&d.Id, &d.Token, &d.Platform); err != nil {
+			return nil, err
+		}
+		ret = append(ret, d)
+	}
+	return ret, rows.Err()
+}
+
+// push a notification to every device of a user, unless their preferences or quiet hours say not to
+func push(userId string, n pushNotification) {
+	prefs, err := loadNotificationPrefs(userId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !prefs.allows(n.Kind) || prefs.quiet(time.Now()) {
+		return
+	}
+
+	devices, err := userDevices(userId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, d := range devices {
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		err = pushProvider.Send(ctx, d, n)
+		cancel()
+
+		if err == errInvalidToken {
+			if _, err = dataBase.Exec(`DELETE FROM device_tokens WHERE token = $1;`, d.Token); err != nil {
+				log.Println(err)
+			}
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// push a direct message to a recipient who was not connected when it arrived
+func pushMessage(m message) {
+	var sender string
+	if err := dataBase.QueryRow(`SELECT name FROM profile WHERE id = $1;`, m.IdFrom).Scan(&sender); err != nil {
+		log.Println(err)
+		return
+	}
+
+	body := m.Msg
+	if body == "" && len(m.Attachments) > 0 {
+		body = "Sent an image"
+	}
+
+	push(m.IdTo, pushNotification{
+		Kind:  "message",
+		Title: sender,
+		Body:  body,
+		Data:  map[string]string{"id_from": m.IdFrom, "msg_id": fmt.Sprint(m.MsgId)},
+	})
+}
+
+// register a device for push notifications, a token moves to whoever registered it last
+func registerDevice(context *gin.Context) {
+	var newDevice device
+
+	if err := context.BindJSON(&newDevice); err != nil {
+		return
+	}
+
+	if newDevice.Token == "" || (newDevice.Platform != "fcm" && newDevice.Platform != "apns") {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("token and platform (fcm or apns) required", []interface{}{newDevice}))
+		return
+	}
+
+	_, err := dataBase.Exec(`
+		INSERT INTO device_tokens (token, id, platform, created_at)
+		VALUES ($1, $2, $3, current_timestamp)
+		ON CONFLICT (token) DO UPDATE SET id = $2, platform = $3, created_at = current_timestamp;
+	`, newDevice.Token, newDevice.Id, newDevice.Platform)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newDevice}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{newDevice}))
+}
+
+func unregisterDevice(context *gin.Context) {
+	var oldDevice device
+
+	if err := context.BindJSON(&oldDevice); err != nil {
+		return
+	}
+
+	_, err := dataBase.Exec(`DELETE FROM device_tokens WHERE id = $1 AND token = $2;`, oldDevice.Id, oldDevice.Token)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{oldDevice}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{oldDevice}))
+}
+
+func getNotificationPrefs(context *gin.Context) {
+	var newId id
+
+	if err := context.BindJSON(&newId); err != nil {
+		return
+	}
+
+	prefs, err := loadNotificationPrefs(newId.Id)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{newId}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{prefs}))
+}
+
+func editNotificationPrefs(context *gin.Context) {
+	prefs := defaultNotificationPrefs("")
+
+	if err := context.BindJSON(&prefs); err != nil {
+		return
+	}
+
+	// quiet hours are either both set or both empty
+	if (prefs.QuietStart == "") != (prefs.QuietEnd == "") {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("quiet_start and quiet_end must be set together", []interface{}{prefs}))
+		return
+	}
+	for _, value := range []string{prefs.QuietStart, prefs.QuietEnd} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseClock(value); err != nil {
+			context.IndentedJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{prefs}))
+			return
+		}
+	}
+	if prefs.UtcOffset < -14*60 || prefs.UtcOffset > 14*60 {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("utc_offset out of range", []interface{}{prefs}))
+		return
+	}
+
+	_, err := dataBase.Exec(`
+		INSERT INTO notification_prefs (id, messages, matches, events, quiet_start, quiet_end, utc_offset)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET messages = $2, matches = $3, events = $4, quiet_start = $5, quiet_end = $6, utc_offset = $7;
+	`, prefs.Id, prefs.Messages, prefs.Matches, prefs.Events, prefs.QuietStart, prefs.QuietEnd, prefs.UtcOffset)
+
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{prefs}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{prefs}))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationPrefsQuiet(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", "2026-10-19 "+clock)
+		assert.NoError(t, err)
+		return parsed
+	}
+
+	prefs := defaultNotificationPrefs("1")
+	assert.False(t, prefs.quiet(at("03:00")))
+
+	// spans midnight
+	prefs.QuietStart, prefs.QuietEnd = "22:00", "07:30"
+	assert.True(t, prefs.quiet(at("23:00")))
+	assert.True(t, prefs.quiet(at("03:00")))
+	assert.False(t, prefs.quiet(at("07:30")))
+	assert.False(t, prefs.quiet(at("12:00")))
+
+	// same day, in the user's time zone
+	prefs.QuietStart, prefs.QuietEnd, prefs.UtcOffset = "13:00", "14:00", 8*60
+	assert.True(t, prefs.quiet(at("05:30")))
+	assert.False(t, prefs.quiet(at("13:30")))
+}
+
+func TestNotificationPrefsAllows(t *testing.T) {
+	prefs := defaultNotificationPrefs("1")
+	prefs.Events = false
+
+	assert.True(t, prefs.allows("message"))
+	assert.True(t, prefs.allows("match"))
+	assert.False(t, prefs.allows("event_reminder"))
+	assert.False(t, prefs.allows("event_cancelled"))
+}
+
+func TestHttpPushProvider(t *testing.T) {
+	var paths []string
+	var bodies []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		paths = append(paths, r.URL.Path)
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+
+		if r.URL.Path == "/3/device/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer ts.Close()
+
+	p := httpPushProvider{fcmUrl: ts.URL + "/fcm", apnsUrl: ts.URL, key: "secret", apnsTopic: "app", client: ts.Client()}
+	n := pushNotification{Kind: "message", Title: "Alice", Body: "hi", Data: map[string]string{"msg_id": "1"}}
+
+	assert.NoError(t, p.Send(context.Background(), device{Token: "abc", Platform: "fcm"}, n))
+	assert.NoError(t, p.Send(context.Background(), device{Token: "def", Platform: "apns"}, n))
+	assert.Equal(t, errInvalidToken, p.Send(context.Background(), device{Token: "gone", Platform: "apns"}, n))
+	assert.Error(t, p.Send(context.Background(), device{Token: "abc", Platform: "sms"}, n))
+
+	assert.Equal(t, []string{"/fcm", "/3/device/def", "/3/device/gone"}, paths)
+
+	fcm := bodies[0]["message"].(map[string]interface{})
+	assert.Equal(t, "abc", fcm["token"])
+	assert.Equal(t, "hi", fcm["notification"].(map[string]interface{})["body"])
+
+	apns := bodies[1]
+	assert.Equal(t, "Alice", apns["aps"].(map[string]interface{})["alert"].(map[string]interface{})["title"])
+	assert.Equal(t, "1", apns["msg_id"])
+}
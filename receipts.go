@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// columns of the messaage table, in the order scanMessage expects them
+const messageColumns = `msg_id, id_from, id_to, msg, time_sent, delivered_at, read_at, edited_at, deleted_at`
+
+// a user received or read every message from peer_id up to msg_id
+type receipt struct {
+	Id     string    `json:"id"`
+	PeerId string    `json:"peer_id"`
+	MsgId  int       `json:"msg_id"`
+	Time   time.Time `json:"time"`
+}
+
+func init() {
+	wsHandlers["read"] = func(c *client, body json.RawMessage) {
+		var cursor readCursor
+		if err := json.Unmarshal(body, &cursor); err != nil {
+			return
+		}
+
+		// the reader is always the owner of the connection
+		cursor.Id = c.id
+		if err := markRead(cursor); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func scanMessage(row scanner, m *message) error {
+	return row.Scan(&m.MsgId, &m.IdFrom, &m.IdTo, &m.Msg, &m.TimeSent, &m.DeliveredAt, &m.ReadAt, &m.EditedAt, &m.DeletedAt)
+}
+
+// mark a message pushed to its recipient as delivered
+func markMessageDelivered(m *message) error {
+	err := dataBase.QueryRow(`
+		UPDATE messaage SET delivered_at = COALESCE(delivered_at, current_timestamp)
+		WHERE msg_id = $1
+		RETURNING delivered_at;
+	`, m.MsgId).Scan(&m.DeliveredAt)
+	if err != nil {
+		return err
+	}
+
+	server.send(m.IdFrom, "delivered", receipt{m.IdTo, m.IdFrom, m.MsgId, *m.DeliveredAt})
+	return nil
+}
+
+// mark every message from peerId that userId has not received yet as delivered
+func markConversationDelivered(userId string, peerId string) error {
+	var last receipt
+	err := dataBase.QueryRow(`
+		WITH delivered AS (
+			UPDATE messaage SET delivered_at = current_timestamp
+			WHERE id_from = $2 AND id_to = $1 AND delivered_at IS NULL
+			RETURNING msg_id, delivered_at
+		)
+		SELECT msg_id, delivered_at FROM delivered ORDER BY msg_id DESC LIMIT 1;
+	`, userId, peerId).Scan(&last.MsgId, &last.Time)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	last.Id = userId
+	last.PeerId = peerId
+	server.send(peerId, "delivered", last)
+	return nil
+}
+
+// move the read cursor and mark the messages up to it read, the sender sees them as seen
+func markRead(cursor readCursor) error {
+	if err := updateReadCursor(cursor.Id, cursor.PeerId, cursor.MsgId); err != nil {
+		return err
+	}
+
+	var readAt time.Time
+	err := dataBase.QueryRow(`
+		WITH seen AS (
+			UPDATE messaage
+			SET read_at = current_timestamp, delivered_at = COALESCE(delivered_at, current_timestamp)
+			WHERE id_from = $2 AND id_to = $1 AND msg_id <= $3 AND read_at IS NULL
+			RETURNING read_at
+		)
+		SELECT COALESCE(MAX(read_at), current_timestamp) FROM seen;
+	`, cursor.Id, cursor.PeerId, cursor.MsgId).Scan(&readAt)
+	if err != nil {
+		return err
+	}
+
+	server.send(cursor.PeerId, "read", receipt{cursor.Id, cursor.PeerId, cursor.MsgId, readAt})
+	return nil
+}
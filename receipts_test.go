@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkMessageDelivered(t *testing.T) {
+	requireDatabase(t)
+	ts := startHub(t)
+
+	sender := createTestProfile(t, "receipts-test-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "receipts-test-recipient@example.com", "Recipient")
+	conn := dialHub(t, ts.URL, sender)
+	defer conn.Close()
+
+	var m message
+	err := scanMessage(dataBase.QueryRow(`SELECT `+messageColumns+` FROM messaage WHERE msg_id = $1;`,
+		insertTestMessage(t, sender, recipient, "hi")), &m)
+	assert.NoError(t, err)
+	assert.Nil(t, m.DeliveredAt)
+
+	assert.NoError(t, markMessageDelivered(&m))
+	assert.NotNil(t, m.DeliveredAt)
+
+	var r receipt
+	assert.NoError(t, json.Unmarshal(readEvent(t, conn, "delivered").Body, &r))
+	assert.Equal(t, recipient, r.Id)
+	assert.Equal(t, sender, r.PeerId)
+	assert.Equal(t, m.MsgId, r.MsgId)
+	assert.True(t, m.DeliveredAt.Equal(r.Time))
+
+	// delivered once, the first time is kept
+	first := *m.DeliveredAt
+	assert.NoError(t, markMessageDelivered(&m))
+	assert.True(t, first.Equal(*m.DeliveredAt))
+}
+
+func TestMarkConversationDelivered(t *testing.T) {
+	requireDatabase(t)
+	ts := startHub(t)
+
+	sender := createTestProfile(t, "receipts-test-offline-sender@example.com", "Sender")
+	recipient := createTestProfile(t, "receipts-test-offline-recipient@example.com", "Recipient")
+	conn := dialHub(t, ts.URL, sender)
+	defer conn.Close()
+
+	insertTestMessage(t, sender, recipient, "are you there?")
+	last := insertTestMessage(t, sender, recipient, "hello?")
+
+	// one receipt for everything the recipient missed
+	assert.NoError(t, markConversationDelivered(recipient, sender))
+	var r receipt
+	assert.NoError(t, json.Unmarshal(readEvent(t, conn, "delivered").Body, &r))
+	assert.Equal(t, last, r.MsgId)
+	assert.Equal(t, recipient, r.Id)
+
+	var undelivered int
+	dataBase.QueryRow(`SELECT count(*) FROM messaage WHERE id_from = $1 AND delivered_at IS NULL;`, sender).Scan(&undelivered)
+	assert.Equal(t, 0, undelivered)
+
+	// nothing left to deliver
+	assert.NoError(t, markConversationDelivered(recipient, sender))
+}
+
+func TestReadReceiptOverWebsocket(t *testing.T) {
+	requireDatabase(t)
+	ts := startHub(t)
+
+	sender := createTestProfile(t, "receipts-test-read-sender@example.com", "Sender")
+	reader := createTestProfile(t, "receipts-test-read-reader@example.com", "Reader")
+	senderConn := dialHub(t, ts.URL, sender)
+	defer senderConn.Close()
+	readerConn := dialHub(t, ts.URL, reader)
+	defer readerConn.Close()
+
+	first := insertTestMessage(t, sender, reader, "one")
+	second := insertTestMessage(t, sender, reader, "two")
+
+	// the reader is the owner of the connection, whatever id the body has
+	body, _ := json.Marshal(readCursor{Id: sender, PeerId: sender, MsgId: first})
+	assert.NoError(t, readerConn.WriteJSON(wsEvent{"read", body}))
+
+	var r receipt
+	assert.NoError(t, json.Unmarshal(readEvent(t, senderConn, "read").Body, &r))
+	assert.Equal(t, reader, r.Id)
+	assert.Equal(t, first, r.MsgId)
+
+	var firstRead, secondRead bool
+	dataBase.QueryRow(`SELECT read_at IS NOT NULL FROM messaage WHERE msg_id = $1;`, first).Scan(&firstRead)
+	dataBase.QueryRow(`SELECT read_at IS NOT NULL FROM messaage WHERE msg_id = $1;`, second).Scan(&secondRead)
+	assert.True(t, firstRead)
+	assert.False(t, secondRead)
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// how far ahead recurring events are expanded into occurrences
+const occurrenceWindow = 90 * 24 * time.Hour
+
+// upper bound on occurrences generated for a single series
+const maxOccurrences = 500
+
+// layout used by UNTIL in RRULE strings
+const rruleTimeLayout = "20060102T150405Z"
+
+// columns of the events table, in the order scanEvent expects them
+const eventColumns = `event_id, user_id, size, description, name, datetime, owner, rrule, sequence, status, cancel_reason`
+
+// subset of RFC 5545 recurrence rules
+type recurrence struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+}
+
+// single occurrence overrides stored in event_occurrences
+type occurrenceOverride struct {
+	UserIds     []int64
+	Size        sql.NullInt64
+	Name        sql.NullString
+	Description sql.NullString
+	DateTime    sql.NullTime
+	Cancelled   bool
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row scanner, e *event) error {
+	return row.Scan(
+		&e.EventId,
+		pq.Array(&e.UserIds),
+		&e.Size,
+		&e.Description,
+		&e.Name,
+		&e.DateTime,
+		&e.Owner,
+		&e.RRule,
+		&e.Sequence,
+		&e.Status,
+		&e.CancelReason)
+}
+
+// parse a rule such as "FREQ=WEEKLY;INTERVAL=2;COUNT=10"
+func parseRRule(rule string) (recurrence, error) {
+	r := recurrence{Interval: 1}
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid rrule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(value)
+			if r.Freq != "DAILY" && r.Freq != "WEEKLY" && r.Freq != "MONTHLY" {
+				return r, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid interval %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid count %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := time.Parse(rruleTimeLayout, value)
+			if err != nil {
+				return r, fmt.Errorf("invalid until %q", value)
+			}
+			r.Until = t
+		default:
+			return r, fmt.Errorf("unsupported rrule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, errors.New("rrule is missing FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return r, errors.New("rrule cannot have both COUNT and UNTIL")
+	}
+	return r, nil
+}
+
+// normalised form stored in the events table
+func (r recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(rruleTimeLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// the n-th step of the series, months that do not have the start's day are skipped
+func (r recurrence) step(start time.Time, n int) (time.Time, bool) {
+	switch r.Freq {
+	case "DAILY":
+		return start.AddDate(0, 0, n*r.Interval), true
+	case "WEEKLY":
+		return start.AddDate(0, 0, 7*n*r.Interval), true
+	default:
+		t := start.AddDate(0, n*r.Interval, 0)
+		return t, t.Day() == start.Day()
+	}
+}
+
+// all occurrences of a series beginning at start that fall within [from, to)
+func (r recurrence) between(start, from, to time.Time) []time.Time {
+	var ret []time.Time
+	count := 0
+
+	for n := 0; len(ret) < maxOccurrences; n++ {
+		t, ok := r.step(start, n)
+		if !ok {
+			continue
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			break
+		}
+		if r.Count > 0 && count >= r.Count {
+			break
+		}
+		if !t.Before(to) {
+			break
+		}
+		count++
+
+		if !t.Before(from) {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// whether a series beginning at start has no occurrences left at or after t, series without COUNT or UNTIL never end
+func (r recurrence) endedBefore(start, t time.Time) bool {
+	if r.Count == 0 && r.Until.IsZero() {
+		return false
+	}
+	return len(r.between(start, t, t.AddDate(100, 0, 0))) == 0
+}
+
+// whether t is one of the series' occurrences
+func (r recurrence) includes(start, t time.Time) bool {
+	for _, o := range r.between(start, t, t.Add(time.Second)) {
+		if o.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadOverrides(eventId string) (map[int64]occurrenceOverride, error) {
+	rows, err := dataBase.Query(`
+		SELECT occurrence, user_id, size, name, description, datetime, cancelled
+		FROM event_occurrences
+		WHERE event_id = $1;
+	`, eventId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[int64]occurrenceOverride)
+	for rows.Next() {
+		var occurrence time.Time
+		var o occurrenceOverride
+		err = rows.Scan(&occurrence, pq.Array(&o.UserIds), &o.Size, &o.Name, &o.Description, &o.DateTime, &o.Cancelled)
+		if err != nil {
+			return nil, err
+		}
+		overrides[occurrence.Unix()] = o
+	}
+	return overrides, rows.Err()
+}
+
+// apply an override on top of the series for one occurrence
+func (o occurrenceOverride) apply(e *event) {
+	if o.UserIds != nil {
+		e.UserIds = o.UserIds
+	}
+	if o.Size.Valid {
+		e.Size = int(o.Size.Int64)
+	}
+	if o.Name.Valid {
+		e.Name = o.Name.String
+	}
+	if o.Description.Valid {
+		e.Description = o.Description.String
+	}
+	if o.DateTime.Valid {
+		e.DateTime = o.DateTime.Time
+	}
+}
+
+// the override an occurrence edit makes, fields left unset keep their current value
+func occurrenceChanges(e event) occurrenceOverride {
+	var o occurrenceOverride
+	if e.Size != 0 {
+		o.Size = sql.NullInt64{Int64: int64(e.Size), Valid: true}
+	}
+	if e.Name != "" {
+		o.Name = sql.NullString{String: e.Name, Valid: true}
+	}
+	if e.Description != "" {
+		o.Description = sql.NullString{String: e.Description, Valid: true}
+	}
+	if !e.DateTime.IsZero() {
+		o.DateTime = sql.NullTime{Time: e.DateTime, Valid: true}
+	}
+	return o
+}
+
+// an override moving from one occurrence of a series to another
+type overrideMove struct {
+	From time.Time
+	To   time.Time
+}
+
+// where the overrides of a series go when its start or rule changes. each override keeps
+// its place in the series, those no longer falling on an occurrence are dropped. moves are
+// ordered so that none lands on an occurrence still waiting to move.
+func migrateOverrides(occurrences []time.Time, oldStart, newStart time.Time, rule recurrence) ([]overrideMove, []time.Time) {
+	shift := newStart.Sub(oldStart)
+	var moves []overrideMove
+	var dropped []time.Time
+
+	for _, o := range occurrences {
+		to := o.Add(shift)
+		if !rule.includes(newStart, to) {
+			dropped = append(dropped, o)
+		} else if shift != 0 {
+			moves = append(moves, overrideMove{o, to})
+		}
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		if shift > 0 {
+			return moves[i].From.After(moves[j].From)
+		}
+		return moves[i].From.Before(moves[j].From)
+	})
+	return moves, dropped
+}
+
+// carry the overrides of a series over to its new start and rule
+func moveOverrides(tx *sql.Tx, eventId string, oldStart time.Time, series event) error {
+	rule, err := parseRRule(series.RRule)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT occurrence FROM event_occurrences WHERE event_id = $1;`, eventId)
+	if err != nil {
+		return err
+	}
+	var occurrences []time.Time
+	for rows.Next() {
+		var o time.Time
+		if err = rows.Scan(&o); err != nil {
+			rows.Close()
+			return err
+		}
+		occurrences = append(occurrences, o)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	moves, dropped := migrateOverrides(occurrences, oldStart, series.DateTime, rule)
+	for _, o := range dropped {
+		if _, err = tx.Exec(`DELETE FROM event_occurrences WHERE event_id = $1 AND occurrence = $2;`, eventId, o); err != nil {
+			return err
+		}
+	}
+	for _, m := range moves {
+		_, err = tx.Exec(`UPDATE event_occurrences SET occurrence = $3 WHERE event_id = $1 AND occurrence = $2;`, eventId, m.From, m.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand a recurring event into its occurrences within [from, to), cancelled occurrences are left out
+func expandEvent(series event, from, to time.Time) ([]event, error) {
+	rule, err := parseRRule(series.RRule)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := loadOverrides(series.EventId)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []event
+	for _, t := range rule.between(series.DateTime, from, to) {
+		occurrence := t
+		temp := series
+		temp.DateTime = t
+		temp.Occurrence = &occurrence
+
+		if o, ok := overrides[t.Unix()]; ok {
+			if o.Cancelled {
+				continue
+			}
+			o.apply(&temp)
+		}
+		ret = append(ret, temp)
+	}
+	return ret, nil
+}
+
+var errOccurrenceCancelled = errors.New("occurrence cancelled")
+
+// load an event and, for recurring events, the state of one of its occurrences. a
+// cancelled occurrence is still loaded, along with errOccurrenceCancelled.
+func loadOccurrence(eventId string, occurrence *time.Time) (event, error) {
+	var ret event
+	err := scanEvent(dataBase.QueryRow(`SELECT `+eventColumns+` FROM events WHERE event_id = $1;`, eventId), &ret)
+	if err != nil || occurrence == nil {
+		return ret, err
+	}
+
+	if ret.RRule == "" {
+		return ret, errors.New("event is not recurring")
+	}
+
+	rule, err := parseRRule(ret.RRule)
+	if err != nil {
+		return ret, err
+	}
+	if !rule.includes(ret.DateTime, *occurrence) {
+		return ret, errors.New("no such occurrence")
+	}
+
+	overrides, err := loadOverrides(eventId)
+	if err != nil {
+		return ret, err
+	}
+
+	ret.DateTime = *occurrence
+	ret.Occurrence = occurrence
+	if o, ok := overrides[occurrence.Unix()]; ok {
+		o.apply(&ret)
+		if o.Cancelled {
+			return ret, errOccurrenceCancelled
+		}
+	}
+	return ret, nil
+}
+
+// upcoming events that are not cancelled, with recurring events expanded into their occurrences
+func upcomingEvents(filter func(event) bool) ([]event, error) {
+	events := []event{}
+	now := time.Now()
+
+	rows, err := dataBase.Query(`SELECT ` + eventColumns + ` FROM events WHERE status = 'active' AND (datetime > now() OR rrule != '');`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []event
+	for rows.Next() {
+		var temp event
+		if err = scanEvent(rows, &temp); err != nil {
+			return nil, err
+		}
+
+		if temp.RRule != "" {
+			series = append(series, temp)
+		} else if filter(temp) {
+			events = append(events, temp)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range series {
+		occurrences, err := expandEvent(s, now, now.Add(occurrenceWindow))
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range occurrences {
+			if filter(o) {
+				events = append(events, o)
+			}
+		}
+	}
+	return events, nil
+}
+
+func hasParticipant(e event, userId int64) bool {
+	for _, item := range e.UserIds {
+		if item == userId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRRule(t *testing.T) {
+	rule, err := parseRRule("FREQ=WEEKLY;INTERVAL=2;COUNT=5")
+	assert.NoError(t, err)
+	assert.Equal(t, recurrence{Freq: "WEEKLY", Interval: 2, Count: 5}, rule)
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;COUNT=5", rule.String())
+
+	rule, err = parseRRule("RRULE:freq=daily;UNTIL=20260301T000000Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "FREQ=DAILY;UNTIL=20260301T000000Z", rule.String())
+
+	_, err = parseRRule("FREQ=YEARLY")
+	assert.Error(t, err)
+
+	_, err = parseRRule("INTERVAL=2")
+	assert.Error(t, err)
+
+	_, err = parseRRule("FREQ=DAILY;COUNT=2;UNTIL=20260301T000000Z")
+	assert.Error(t, err)
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	start := time.Date(2026, 1, 5, 18, 0, 0, 0, time.UTC)
+
+	// weekly with a count, window starting after the first occurrence
+	rule := recurrence{Freq: "WEEKLY", Interval: 1, Count: 3}
+	got := rule.between(start, start.Add(time.Hour), start.AddDate(1, 0, 0))
+	assert.Equal(t, []time.Time{start.AddDate(0, 0, 7), start.AddDate(0, 0, 14)}, got)
+
+	// daily until
+	rule = recurrence{Freq: "DAILY", Interval: 2, Until: start.AddDate(0, 0, 4)}
+	got = rule.between(start, start, start.AddDate(1, 0, 0))
+	assert.Equal(t, []time.Time{start, start.AddDate(0, 0, 2), start.AddDate(0, 0, 4)}, got)
+
+	// monthly on the 31st skips short months
+	start = time.Date(2026, 1, 31, 18, 0, 0, 0, time.UTC)
+	rule = recurrence{Freq: "MONTHLY", Interval: 1, Count: 3}
+	got = rule.between(start, start, start.AddDate(1, 0, 0))
+	assert.Equal(t, []time.Time{start, start.AddDate(0, 2, 0), start.AddDate(0, 4, 0)}, got)
+
+	assert.True(t, rule.includes(start, start.AddDate(0, 2, 0)))
+	assert.False(t, rule.includes(start, start.AddDate(0, 1, 0)))
+}
+
+func TestOccurrenceChanges(t *testing.T) {
+	start := time.Date(2026, 1, 5, 18, 0, 0, 0, time.UTC)
+	series := event{Size: 10, Name: "Run club", Description: "5k", DateTime: start}
+
+	// only the name is set, the rest stays as in the series
+	changes := occurrenceChanges(event{Name: "Run club (indoors)"})
+	assert.False(t, changes.Size.Valid)
+	assert.False(t, changes.DateTime.Valid)
+
+	temp := series
+	changes.apply(&temp)
+	assert.Equal(t, event{Size: 10, Name: "Run club (indoors)", Description: "5k", DateTime: start}, temp)
+
+	moved := start.Add(time.Hour)
+	changes = occurrenceChanges(event{Size: 4, DateTime: moved})
+	temp = series
+	changes.apply(&temp)
+	assert.Equal(t, event{Size: 4, Name: "Run club", Description: "5k", DateTime: moved}, temp)
+}
+
+func TestMigrateOverrides(t *testing.T) {
+	start := time.Date(2026, 1, 5, 18, 0, 0, 0, time.UTC)
+	week := func(n int) time.Time { return start.AddDate(0, 0, 7*n) }
+	rule := recurrence{Freq: "WEEKLY", Interval: 1}
+
+	// the start moves an hour later, every override moves with it, latest first
+	later := start.Add(time.Hour)
+	moves, dropped := migrateOverrides([]time.Time{week(1), week(2)}, start, later, rule)
+	assert.Equal(t, []overrideMove{{week(2), week(2).Add(time.Hour)}, {week(1), week(1).Add(time.Hour)}}, moves)
+	assert.Empty(t, dropped)
+
+	// a week earlier, earliest first
+	moves, _ = migrateOverrides([]time.Time{week(2), week(1)}, start, week(-1), rule)
+	assert.Equal(t, []overrideMove{{week(1), week(0)}, {week(2), week(1)}}, moves)
+
+	// every other week now, overrides on the weeks in between go
+	moves, dropped = migrateOverrides([]time.Time{week(1), week(2)}, start, start, recurrence{Freq: "WEEKLY", Interval: 2})
+	assert.Empty(t, moves)
+	assert.Equal(t, []time.Time{week(1)}, dropped)
+
+	// a count that ends the series before an override
+	_, dropped = migrateOverrides([]time.Time{week(1), week(5)}, start, start, recurrence{Freq: "WEEKLY", Interval: 1, Count: 3})
+	assert.Equal(t, []time.Time{week(5)}, dropped)
+}
+
+func TestRecurrenceEndedBefore(t *testing.T) {
+	start := time.Date(2026, 1, 5, 18, 0, 0, 0, time.UTC)
+
+	rule := recurrence{Freq: "WEEKLY", Interval: 1, Count: 3}
+	assert.False(t, rule.endedBefore(start, start.AddDate(0, 0, 14)))
+	assert.True(t, rule.endedBefore(start, start.AddDate(0, 0, 15)))
+
+	rule = recurrence{Freq: "DAILY", Interval: 1, Until: start.AddDate(0, 0, 3)}
+	assert.True(t, rule.endedBefore(start, start.AddDate(0, 0, 4)))
+
+	// without an end it never ends
+	rule = recurrence{Freq: "MONTHLY", Interval: 1}
+	assert.False(t, rule.endedBefore(start, start.AddDate(10, 0, 0)))
+}
+
+func TestRestoreOccurrence(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/editEvent", requireSession(), editEvent)
+
+	owner := createTestUser(t, "occurrence-test-owner@example.com")
+	eventId := createTestEvent(t, owner, "{"+owner+"}")
+	var start time.Time
+	err := dataBase.QueryRow(`UPDATE events SET rrule = 'FREQ=WEEKLY' WHERE event_id = $1 RETURNING datetime;`, eventId).Scan(&start)
+	assert.NoError(t, err)
+	occurrence := start.AddDate(0, 0, 7)
+
+	edit := func(cancelled bool) {
+		request := editEventRequest{Scope: "occurrence", Cancelled: cancelled}
+		request.EventId = eventId
+		request.Occurrence = &occurrence
+		w := performRequestAs(t, router, http.MethodPatch, "/editEvent", owner, request)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"err_msg": "ok"`)
+	}
+
+	edit(true)
+	_, err = loadOccurrence(eventId, &occurrence)
+	assert.Equal(t, errOccurrenceCancelled, err)
+
+	// cancelling an occurrence can be undone
+	edit(false)
+	_, err = loadOccurrence(eventId, &occurrence)
+	assert.NoError(t, err)
+
+	var sequence int
+	dataBase.QueryRow(`SELECT sequence FROM events WHERE event_id = $1;`, eventId).Scan(&sequence)
+	assert.Equal(t, 2, sequence)
+}
+
+func TestJoinSeriesSkipsFullOccurrence(t *testing.T) {
+	requireDatabase(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/addIdToEvent", addToEvent)
+
+	owner := createTestUser(t, "join-test-owner@example.com")
+	member := createTestUser(t, "join-test-member@example.com")
+	eventId := createTestEvent(t, owner, "{"+owner+"}")
+	var start time.Time
+	err := dataBase.QueryRow(`UPDATE events SET rrule = 'FREQ=WEEKLY' WHERE event_id = $1 RETURNING datetime;`, eventId).Scan(&start)
+	assert.NoError(t, err)
+	full, open := start.AddDate(0, 0, 7), start.AddDate(0, 0, 14)
+	_, err = dataBase.Exec(`
+		INSERT INTO event_occurrences (event_id, occurrence, user_id, size)
+		VALUES ($1, $2, $4::bigint[], 1), ($1, $3, $4::bigint[], NULL);
+	`, eventId, full, open, "{"+owner+"}")
+	assert.NoError(t, err)
+
+	memberId, _ := strconv.ParseInt(member, 10, 64)
+	w := performRequest(router, http.MethodPost, "/addIdToEvent", addEvent{UserId: memberId, EventId: eventId})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	current, err := loadOccurrence(eventId, &full)
+	assert.NoError(t, err)
+	assert.False(t, hasParticipant(current, memberId))
+	current, err = loadOccurrence(eventId, &open)
+	assert.NoError(t, err)
+	assert.True(t, hasParticipant(current, memberId))
+}
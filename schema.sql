@@ -0,0 +1,240 @@
+-- Schema changes required by the backend, apply in order on top of the existing database.
+
+-- recurring events
+ALTER TABLE events ADD COLUMN IF NOT EXISTS rrule text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS event_occurrences (
+    event_id    integer     NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+    occurrence  timestamptz NOT NULL,
+    user_id     bigint[]    NOT NULL DEFAULT '{}',
+    size        integer,
+    name        text,
+    description text,
+    datetime    timestamptz,
+    cancelled   boolean     NOT NULL DEFAULT false,
+    PRIMARY KEY (event_id, occurrence)
+);
+
+-- calendar export
+ALTER TABLE events ADD COLUMN IF NOT EXISTS sequence integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS removed_events (
+    event_id   integer     NOT NULL,
+    user_id    bigint[]    NOT NULL DEFAULT '{}',
+    name       text        NOT NULL,
+    datetime   timestamptz NOT NULL,
+    rrule      text        NOT NULL DEFAULT '',
+    sequence   integer     NOT NULL,
+    removed_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS calendar_tokens (
+    id    bigint PRIMARY KEY,
+    token text   NOT NULL UNIQUE
+);
+
+-- event group chats
+CREATE TABLE IF NOT EXISTS event_messages (
+    msg_id    serial      PRIMARY KEY,
+    event_id  integer     NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+    id_from   bigint      NOT NULL,
+    msg       text        NOT NULL,
+    time_sent timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS event_messages_event_id_time_sent ON event_messages (event_id, time_sent);
+
+-- notifications and background jobs
+CREATE TABLE IF NOT EXISTS notifications (
+    notification_id serial      PRIMARY KEY,
+    id              bigint      NOT NULL,
+    kind            text        NOT NULL,
+    title           text        NOT NULL,
+    msg             text        NOT NULL,
+    time_sent       timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS notifications_id_time_sent ON notifications (id, time_sent);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    name       text        PRIMARY KEY,
+    last_run   timestamptz,
+    next_run   timestamptz NOT NULL,
+    last_error text        NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS event_reminders (
+    event_id    integer     NOT NULL REFERENCES events (event_id) ON DELETE CASCADE,
+    occurrence  timestamptz NOT NULL,
+    reminded_at timestamptz NOT NULL,
+    PRIMARY KEY (event_id, occurrence)
+);
+
+ALTER TABLE not_interested ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT current_timestamp;
+
+-- event cancellation
+ALTER TABLE events ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'active';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS cancel_reason text NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN IF NOT EXISTS cancelled_at timestamptz;
+
+ALTER TABLE auth ADD COLUMN IF NOT EXISTS is_admin boolean NOT NULL DEFAULT false;
+
+-- conversation read cursors
+CREATE TABLE IF NOT EXISTS read_cursors (
+    id               bigint      NOT NULL,
+    peer_id          bigint      NOT NULL,
+    last_read_msg_id integer     NOT NULL DEFAULT 0,
+    updated_at       timestamptz NOT NULL,
+    PRIMARY KEY (id, peer_id)
+);
+
+-- message delivery and read receipts
+ALTER TABLE messaage ADD COLUMN IF NOT EXISTS delivered_at timestamptz;
+ALTER TABLE messaage ADD COLUMN IF NOT EXISTS read_at timestamptz;
+
+-- editing and deleting messages
+ALTER TABLE messaage ADD COLUMN IF NOT EXISTS edited_at timestamptz;
+ALTER TABLE messaage ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    msg_id    integer     NOT NULL,
+    msg       text        NOT NULL,
+    edited_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_msg_id ON message_edits (msg_id);
+
+-- message attachments
+CREATE TABLE IF NOT EXISTS attachments (
+    attachment_id serial      PRIMARY KEY,
+    owner         bigint      NOT NULL,
+    msg_id        integer,
+    content_type  text        NOT NULL,
+    size          bigint      NOT NULL,
+    blob_key      text        NOT NULL UNIQUE,
+    created_at    timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_msg_id ON attachments (msg_id);
+
+-- message search
+CREATE INDEX IF NOT EXISTS messaage_msg_search ON messaage USING gin (to_tsvector('simple', msg));
+
+-- content moderation
+CREATE TABLE IF NOT EXISTS moderation_queue (
+    item_id     serial      PRIMARY KEY,
+    kind        text        NOT NULL,
+    ref_id      text        NOT NULL,
+    user_id     text        NOT NULL,
+    content     text        NOT NULL,
+    rules       text[]      NOT NULL,
+    created_at  timestamptz NOT NULL,
+    reviewed_at timestamptz
+);
+
+-- push notifications
+CREATE TABLE IF NOT EXISTS device_tokens (
+    token      text        PRIMARY KEY,
+    id         bigint      NOT NULL,
+    platform   text        NOT NULL,
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_tokens_id ON device_tokens (id);
+
+CREATE TABLE IF NOT EXISTS notification_prefs (
+    id          bigint  PRIMARY KEY,
+    messages    boolean NOT NULL DEFAULT true,
+    matches     boolean NOT NULL DEFAULT true,
+    events      boolean NOT NULL DEFAULT true,
+    quiet_start text    NOT NULL DEFAULT '',
+    quiet_end   text    NOT NULL DEFAULT '',
+    utc_offset  integer NOT NULL DEFAULT 0
+);
+
+-- password reset
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash text        PRIMARY KEY,
+    id         bigint      NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_id ON password_resets (id);
+
+-- email verification, accounts that existed before verification was added count as verified
+ALTER TABLE auth ADD COLUMN IF NOT EXISTS verified boolean NOT NULL DEFAULT true;
+ALTER TABLE auth ALTER COLUMN verified SET DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash text        PRIMARY KEY,
+    id         bigint      NOT NULL,
+    email      text        NOT NULL,
+    expires_at timestamptz NOT NULL,
+    sent_at    timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_id ON email_verifications (id);
+
+-- two-factor authentication
+CREATE TABLE IF NOT EXISTS totp (
+    id         bigint      PRIMARY KEY,
+    secret     text        NOT NULL,
+    enabled    boolean     NOT NULL DEFAULT false,
+    enabled_at timestamptz,
+    last_step  bigint      NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id        bigint      NOT NULL,
+    code_hash text        NOT NULL,
+    used_at   timestamptz,
+    PRIMARY KEY (id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token_hash text        PRIMARY KEY,
+    id         bigint      NOT NULL,
+    expires_at timestamptz NOT NULL,
+    attempts   integer     NOT NULL DEFAULT 0
+);
+
+-- account deletion
+CREATE TABLE IF NOT EXISTS account_deletions (
+    id           bigint      PRIMARY KEY,
+    requested_at timestamptz NOT NULL,
+    delete_after timestamptz NOT NULL
+);
+
+-- sessions
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id serial      PRIMARY KEY,
+    token_hash text        NOT NULL UNIQUE,
+    id         bigint      NOT NULL,
+    device     text        NOT NULL DEFAULT '',
+    ip         text        NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL,
+    last_used  timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_id ON sessions (id);
+
+-- sign in with OpenID Connect providers
+CREATE TABLE IF NOT EXISTS identities (
+    provider   text        NOT NULL,
+    subject    text        NOT NULL,
+    id         bigint      NOT NULL,
+    email      text        NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS identities_id ON identities (id);
+
+-- when the account finished onboarding, kept as locations are purged after a day
+ALTER TABLE auth ADD COLUMN IF NOT EXISTS onboarded_at timestamptz;
+UPDATE auth SET onboarded_at = current_timestamp
+WHERE onboarded_at IS NULL
+AND EXISTS (SELECT 1 FROM profile WHERE profile.id::text = auth.id::text AND profile.name <> '' AND profile.age > 0)
+AND EXISTS (SELECT 1 FROM tags WHERE tags.id::text = auth.id::text)
+AND EXISTS (SELECT 1 FROM geog WHERE geog.id::text = auth.id::text);
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hits returned per search request
+const searchLimit = 20
+
+// words of context kept around the first match in a snippet
+const snippetWords = 20
+
+// ts_headline marks matches with these, they are turned into tags once the rest of the snippet is escaped
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+type searchRequest struct {
+	Id       string    `json:"id"`
+	Query    string    `json:"query"`
+	TimeSent time.Time `json:"time_sent"`
+}
+
+type searchHit struct {
+	MsgId    int       `json:"msg_id"`
+	PeerId   string    `json:"peer_id"`
+	Snippet  string    `json:"snippet"`
+	TimeSent time.Time `json:"time_sent"`
+	Cursor   time.Time `json:"cursor"`
+}
+
+// full text search over the messages a user sent or received
+type messageSearcher interface {
+	// hits sent before the given time, newest first
+	Search(userId string, query string, before time.Time, limit int) ([]searchHit, error)
+}
+
+var searcher messageSearcher = pgMessageSearcher{}
+
+// search using Postgres full text search on the messaage table
+type pgMessageSearcher struct{}
+
+// search over messages held in memory, matches the same way plainto_tsquery with the simple configuration does
+type memoryMessageSearcher struct {
+	msgs []message
+}
+
+func (pgMessageSearcher) Search(userId string, query string, before time.Time, limit int) ([]searchHit, error) {
+	rows, err := dataBase.Query(`
+		SELECT msg_id,
+			CASE WHEN id_from = $1 THEN id_to ELSE id_from END,
+			ts_headline('simple', translate(msg, chr(2) || chr(3), ''), plainto_tsquery('simple', $2), $5),
+			time_sent
+		FROM messaage
+		WHERE (id_from = $1 OR id_to = $1)
+		AND deleted_at IS NULL
+		AND to_tsvector('simple', msg) @@ plainto_tsquery('simple', $2)
+		AND time_sent < $3
+		ORDER BY time_sent DESC
+		LIMIT $4;
+	`, userId, query, before, limit, "StartSel="+highlightStart+", StopSel="+highlightStop+", MaxWords=20, MinWords=5")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := []searchHit{}
+	for rows.Next() {
+		var temp searchHit
+		if err = rows.Scan(&temp.MsgId, &temp.PeerId, &temp.Snippet, &temp.TimeSent); err != nil {
+			return nil, err
+		}
+		temp.Snippet = escapeSnippet(temp.Snippet)
+		ret = append(ret, temp)
+	}
+	return ret, rows.Err()
+}
+
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// escape a snippet marked by ts_headline for HTML, then tag its matches
+func escapeSnippet(snippet string) string {
+	return strings.NewReplacer(highlightStart, "<b>", highlightStop, "</b>").Replace(html.EscapeString(snippet))
+}
+
+// highlight matched words and cut the message down to a window around the first match, the text is escaped for HTML
+func highlight(msg string, terms map[string]bool) string {
+	words := strings.Fields(msg)
+	first := -1
+	for i, w := range words {
+		words[i] = html.EscapeString(w)
+		for _, t := range searchTerms(w) {
+			if terms[t] {
+				words[i] = "<b>" + words[i] + "</b>"
+				if first < 0 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+
+	start := 0
+	if len(words) > snippetWords && first > 5 {
+		start = first - 5
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	return strings.Join(words[start:end], " ")
+}
+
+func (s memoryMessageSearcher) Search(userId string, query string, before time.Time, limit int) ([]searchHit, error) {
+	terms := make(map[string]bool)
+	for _, t := range searchTerms(query) {
+		terms[t] = true
+	}
+
+	ret := []searchHit{}
+	if len(terms) == 0 {
+		return ret, nil
+	}
+
+	for _, m := range s.msgs {
+		if (m.IdFrom != userId && m.IdTo != userId) || m.DeletedAt != nil || !m.TimeSent.Before(before) {
+			continue
+		}
+
+		words := make(map[string]bool)
+		for _, w := range searchTerms(m.Msg) {
+			words[w] = true
+		}
+
+		// every term of the query has to appear
+		matched := true
+		for t := range terms {
+			if !words[t] {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		peer := m.IdFrom
+		if m.IdFrom == userId {
+			peer = m.IdTo
+		}
+		ret = append(ret, searchHit{MsgId: m.MsgId, PeerId: peer, Snippet: highlight(m.Msg, terms), TimeSent: m.TimeSent})
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].TimeSent.After(ret[j].TimeSent)
+	})
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+// search the caller's messages, each hit has a cursor to open the conversation at that message
+func searchMessage(context *gin.Context) {
+	var request searchRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	if strings.TrimSpace(request.Query) == "" {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("query required", []interface{}{request}))
+		return
+	}
+	if request.TimeSent.IsZero() {
+		request.TimeSent = time.Now()
+	}
+
+	hits, err := searcher.Search(request.Id, request.Query, request.TimeSent, searchLimit)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	// getMessage returns messages sent before time_sent, so step just past the hit to include it
+	for i := range hits {
+		hits[i].Cursor = hits[i].TimeSent.Add(time.Microsecond)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{hits}))
+}
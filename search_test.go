@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryMessageSearcher(t *testing.T) {
+	now := time.Now()
+	deleted := now
+	s := memoryMessageSearcher{[]message{
+		{MsgId: 1, IdFrom: "1", IdTo: "2", Msg: "Dinner at the ramen place?", TimeSent: now.Add(-3 * time.Hour)},
+		{MsgId: 2, IdFrom: "2", IdTo: "1", Msg: "Ramen sounds great, dinner at 7", TimeSent: now.Add(-2 * time.Hour)},
+		{MsgId: 3, IdFrom: "3", IdTo: "4", Msg: "Ramen dinner", TimeSent: now.Add(-time.Hour)},
+		{MsgId: 4, IdFrom: "1", IdTo: "5", Msg: "", TimeSent: now.Add(-time.Minute), DeletedAt: &deleted},
+	}}
+
+	hits, err := s.Search("1", "RAMEN dinner", now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, hits, 2)
+	assert.Equal(t, 2, hits[0].MsgId)
+	assert.Equal(t, "2", hits[0].PeerId)
+	assert.Equal(t, "<b>Ramen</b> sounds great, <b>dinner</b> at 7", hits[0].Snippet)
+	assert.Equal(t, 1, hits[1].MsgId)
+
+	// older than a cursor
+	hits, _ = s.Search("1", "ramen", now.Add(-150*time.Minute), 10)
+	assert.Len(t, hits, 1)
+	assert.Equal(t, 1, hits[0].MsgId)
+
+	// every term has to match
+	hits, _ = s.Search("1", "ramen sushi", now, 10)
+	assert.Empty(t, hits)
+
+	hits, _ = s.Search("1", "ramen", now, 1)
+	assert.Len(t, hits, 1)
+}
+
+func TestSearchSnippetEscaped(t *testing.T) {
+	s := memoryMessageSearcher{[]message{
+		{MsgId: 1, IdFrom: "1", IdTo: "2", Msg: `<img src=x onerror="alert(1)"> ramen & <b>dinner</b>`, TimeSent: time.Now()},
+	}}
+
+	hits, err := s.Search("1", "ramen", time.Now().Add(time.Minute), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, hits, 1) {
+		assert.Equal(t, `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <b>ramen</b> &amp; &lt;b&gt;dinner&lt;/b&gt;`, hits[0].Snippet)
+	}
+
+	// what ts_headline returns
+	assert.Equal(t, `&lt;script&gt; <b>ramen</b>`, escapeSnippet("<script> \x02ramen\x03"))
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// how long a session is trusted without looking it up, revocations on other instances take this long to apply
+	sessionCacheTTL = 30 * time.Second
+	sessionCacheMax = 10000
+)
+
+type session struct {
+	SessionId int       `json:"session_id"`
+	Id        string    `json:"id"`
+	Device    string    `json:"device"`
+	Ip        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	Current   bool      `json:"current"`
+}
+
+// returned once at login, only its hash is stored
+type sessionToken struct {
+	Token string `json:"token"`
+}
+
+type cachedSession struct {
+	session session
+	expires time.Time
+}
+
+// sessions recently checked against the database, keyed by token hash
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]cachedSession
+}
+
+var sessions = newSessionCache(sessionCacheTTL, sessionCacheMax)
+
+func newSessionCache(ttl time.Duration, max int) *sessionCache {
+	return &sessionCache{ttl: ttl, max: max, entries: make(map[string]cachedSession)}
+}
+
+func (c *sessionCache) get(hash string, now time.Time) (session, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[hash]
+	if !ok || now.After(entry.expires) {
+		delete(c.entries, hash)
+		return session{}, false
+	}
+	return entry.session, true
+}
+
+func (c *sessionCache) put(hash string, s session, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.max {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	// still full of live sessions, they are cheap to look up again
+	if len(c.entries) >= c.max {
+		c.entries = make(map[string]cachedSession)
+	}
+	c.entries[hash] = cachedSession{s, now.Add(c.ttl)}
+}
+
+func (c *sessionCache) remove(hashes ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, hash := range hashes {
+		delete(c.entries, hash)
+	}
+}
+
+// the bearer token of a request, empty when there is none
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// start a session for a user who has logged in
+func createSession(userId int, device string, ip string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dataBase.Exec(`
+		INSERT INTO sessions (token_hash, id, device, ip, created_at, last_used)
+		VALUES ($1, $2, $3, $4, current_timestamp, current_timestamp);
+	`, hashToken(token), userId, device, ip)
+	return token, err
+}
+
+// look up a session that has not been revoked or left idle, recording its use
+func loadSession(hash string, ip string) (session, error) {
+	var s session
+	err := dataBase.QueryRow(`
+		UPDATE sessions SET last_used = current_timestamp, ip = $2
+		WHERE token_hash = $1 AND last_used > $3
+		RETURNING session_id, id, device, ip, created_at, last_used;
+	`, hash, ip, time.Now().Add(-envDuration("SESSION_IDLE_TIMEOUT", 30*24*time.Hour))).Scan(
+		&s.SessionId, &s.Id, &s.Device, &s.Ip, &s.CreatedAt, &s.LastUsed)
+	return s, err
+}
+
+// something that can run a query, a *sql.DB or a *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// revoke sessions of a user matching the extra condition, which may use $2, returns how many were revoked
+func revokeWhere(db querier, userId string, condition string, args ...interface{}) (int, error) {
+	rows, err := db.Query(`DELETE FROM sessions WHERE id = $1 `+condition+` RETURNING token_hash;`, append([]interface{}{userId}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	sessions.remove(hashes...)
+	return len(hashes), rows.Err()
+}
+
+// sign a user out everywhere
+func revokeSessions(db querier, userId string) error {
+	_, err := revokeWhere(db, userId, "")
+	return err
+}
+
+// reject requests without a valid session, the session is then available as "session"
+func requireSession() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if authenticate(context, bearerToken(context.Request)) {
+			context.Next()
+		}
+	}
+}
+
+// like requireSession for websockets. browsers cannot set headers on them, so the
+// session token may also be given as the token query parameter.
+func requireSocketSession() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		token := bearerToken(context.Request)
+		if token == "" {
+			token = context.Query("token")
+		}
+		if authenticate(context, token) {
+			context.Next()
+		}
+	}
+}
+
+// like requireSession, and the user given by the field of the JSON body becomes the
+// session's user, so handlers act for whoever is logged in whatever the body says
+func requireUser(field string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !authenticate(context, bearerToken(context.Request)) {
+			return
+		}
+
+		body, err := io.ReadAll(context.Request.Body)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusBadRequest, newResponse(err.Error(), []interface{}{}))
+			return
+		}
+
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(body, &fields); err == nil && fields != nil {
+			// keys match fields case-insensitively when binding, and some requests give the user as a number
+			number := false
+			for key, raw := range fields {
+				if strings.EqualFold(key, field) {
+					number = number || (len(raw) > 0 && strings.IndexByte("-0123456789", raw[0]) >= 0)
+					delete(fields, key)
+				}
+			}
+			userId := currentSession(context).Id
+			if number {
+				fields[field] = json.RawMessage(userId)
+			} else {
+				fields[field], _ = json.Marshal(userId)
+			}
+			if body, err = json.Marshal(fields); err != nil {
+				context.AbortWithStatusJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+				return
+			}
+		}
+		// the handler answers malformed bodies
+		context.Request.Body = io.NopCloser(bytes.NewReader(body))
+		context.Request.ContentLength = int64(len(body))
+
+		context.Next()
+	}
+}
+
+// check the session token, the session is then available as "session". the request is
+// answered and aborted when the token is missing or not a live session.
+func authenticate(context *gin.Context, token string) bool {
+	if token == "" {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, newResponse("session required", []interface{}{}))
+		return false
+	}
+
+	hash := hashToken(token)
+	s, ok := sessions.get(hash, time.Now())
+	if !ok {
+		var err error
+		s, err = loadSession(hash, context.ClientIP())
+		if err == sql.ErrNoRows {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, newResponse("invalid session", []interface{}{}))
+			return false
+		} else if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, newResponse(err.Error(), []interface{}{}))
+			return false
+		}
+		sessions.put(hash, s, time.Now())
+	}
+
+	context.Set("session", s)
+	context.Set("tokenHash", hash)
+	return true
+}
+
+func currentSession(context *gin.Context) session {
+	return context.MustGet("session").(session)
+}
+
+// every session of the logged in user, most recently used first
+func getSessions(context *gin.Context) {
+	current := currentSession(context)
+
+	rows, err := dataBase.Query(`
+		SELECT session_id, id, device, ip, created_at, last_used FROM sessions
+		WHERE id = $1
+		ORDER BY last_used DESC;
+	`, current.Id)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	defer rows.Close()
+
+	ret := []session{}
+	for rows.Next() {
+		var temp session
+		if err = rows.Scan(&temp.SessionId, &temp.Id, &temp.Device, &temp.Ip, &temp.CreatedAt, &temp.LastUsed); err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+			return
+		}
+		temp.Current = temp.SessionId == current.SessionId
+		ret = append(ret, temp)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{ret}))
+}
+
+// sign out one of the logged in user's sessions
+func revokeSession(context *gin.Context) {
+	current := currentSession(context)
+
+	sessionId, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid session id", []interface{}{context.Param("id")}))
+		return
+	}
+
+	revoked, err := revokeWhere(dataBase, current.Id, "AND session_id = $2", sessionId)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	if revoked == 0 {
+		context.IndentedJSON(http.StatusNotFound, newResponse("session not found", []interface{}{context.Param("id")}))
+		return
+	}
+	server.disconnectSession(current.Id, sessionId)
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{}))
+}
+
+func logout(context *gin.Context) {
+	current := currentSession(context)
+
+	if _, err := revokeWhere(dataBase, current.Id, "AND token_hash = $2", context.GetString("tokenHash")); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	server.disconnectSession(current.Id, current.SessionId)
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{}))
+}
+
+func logoutAll(context *gin.Context) {
+	current := currentSession(context)
+
+	if err := revokeSessions(dataBase, current.Id); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	server.disconnect(current.Id)
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{}))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionCache(t *testing.T) {
+	now := time.Now()
+	c := newSessionCache(time.Minute, 2)
+
+	c.put("a", session{SessionId: 1}, now)
+	s, ok := c.get("a", now.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 1, s.SessionId)
+
+	_, ok = c.get("a", now.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	// revoked sessions are dropped straight away
+	c.put("b", session{SessionId: 2}, now)
+	c.remove("b")
+	_, ok = c.get("b", now)
+	assert.False(t, ok)
+
+	// never grows past max
+	c.put("c", session{}, now)
+	c.put("d", session{}, now)
+	c.put("e", session{}, now)
+	assert.LessOrEqual(t, len(c.entries), 2)
+	_, ok = c.get("e", now)
+	assert.True(t, ok)
+}
+
+func TestBearerToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "", bearerToken(r))
+
+	r.Header.Set("Authorization", "bearer abc")
+	assert.Equal(t, "abc", bearerToken(r))
+
+	r.Header.Set("Authorization", "Basic abc")
+	assert.Equal(t, "", bearerToken(r))
+}
+
+func TestRequireSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", requireSession(), func(context *gin.Context) {
+		context.String(http.StatusOK, currentSession(context).Id)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// cached sessions are trusted without a database lookup
+	sessions.put(hashToken("token"), session{SessionId: 1, Id: "7"}, time.Now())
+	defer sessions.remove(hashToken("token"))
+
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "7", w.Body.String())
+}
+
+func TestRequireUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/messages", requireUser("id_from"), func(context *gin.Context) {
+		var request message
+		if err := context.BindJSON(&request); err != nil {
+			return
+		}
+		context.String(http.StatusOK, request.IdFrom)
+	})
+	router.POST("/events", requireUser("id"), func(context *gin.Context) {
+		var request addEvent
+		if err := context.BindJSON(&request); err != nil {
+			return
+		}
+		context.String(http.StatusOK, strconv.FormatInt(request.UserId, 10))
+	})
+
+	post := func(path string, body string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("/messages", `{"id_from": "7"}`, "").Code)
+
+	sessions.put(hashToken("user-token"), session{SessionId: 1, Id: "7"}, time.Now())
+	defer sessions.remove(hashToken("user-token"))
+
+	// another user's id, in any case, is replaced by the session's user
+	for _, body := range []string{`{"id_from": "8"}`, `{"ID_FROM": "8", "msg": "hi"}`, `{"msg": "hi"}`} {
+		w := post("/messages", body, "user-token")
+		assert.Equal(t, http.StatusOK, w.Code, body)
+		assert.Equal(t, "7", w.Body.String(), body)
+	}
+
+	// numeric ids stay numbers
+	w := post("/events", `{"id": 8, "event_id": "1"}`, "user-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "7", w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, post("/messages", `not json`, "user-token").Code)
+}
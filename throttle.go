@@ -0,0 +1,258 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// keeps failed attempt counters and lockouts, shared by every instance when backed by Redis
+type attemptStore interface {
+	// count a failure for key, returns the failures since the counter was created, which expires after window
+	Incr(key string, window time.Duration) (int, error)
+	Lock(key string, until time.Time) error
+	LockedUntil(key string) (time.Time, error)
+	Reset(key string) error
+}
+
+// locks keys out for longer after each failure past the free ones
+type throttle struct {
+	store  attemptStore
+	free   int
+	base   time.Duration
+	max    time.Duration
+	window time.Duration
+}
+
+// counters for login and register, in Redis when REDIS_URL is set and in memory otherwise
+var loginThrottle = throttle{
+	store:  newAttemptStore(envOr("REDIS_URL", "")),
+	free:   5,
+	base:   time.Second,
+	max:    15 * time.Minute,
+	window: time.Hour,
+}
+
+func newAttemptStore(redisUrl string) attemptStore {
+	if redisUrl == "" {
+		return newMemoryAttemptStore()
+	}
+	return redisAttemptStore{&redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 4 * time.Minute,
+		Dial:        func() (redis.Conn, error) { return redis.DialURL(redisUrl) },
+	}}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockout after the nth failure, doubling from base up to max
+func (t throttle) backoff(n int) time.Duration {
+	if n <= t.free {
+		return 0
+	}
+	d := time.Duration(float64(t.base) * math.Pow(2, float64(n-t.free-1)))
+	if d > t.max || d <= 0 {
+		return t.max
+	}
+	return d
+}
+
+// how long until every key may be tried again, zero when none is locked
+func (t throttle) wait(now time.Time, keys ...string) (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range keys {
+		until, err := t.store.LockedUntil(key)
+		if err != nil {
+			return 0, err
+		}
+		if d := until.Sub(now); d > longest {
+			longest = d
+		}
+	}
+	return longest, nil
+}
+
+// count a failure against every key, locking out those past the free attempts
+func (t throttle) fail(now time.Time, keys ...string) error {
+	for _, key := range keys {
+		n, err := t.store.Incr(key, t.window)
+		if err != nil {
+			return err
+		}
+		if d := t.backoff(n); d > 0 {
+			if err = t.store.Lock(key, now.Add(d)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t throttle) succeed(keys ...string) error {
+	for _, key := range keys {
+		if err := t.store.Reset(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seconds for a Retry-After header, rounded up
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+type attemptEntry struct {
+	count   int
+	expires time.Time
+	locked  time.Time
+}
+
+// how often the memory store drops expired entries of keys that were not tried again
+const attemptSweepInterval = time.Minute
+
+// counters for a single instance
+type memoryAttemptStore struct {
+	mu        sync.Mutex
+	entries   map[string]*attemptEntry
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{entries: make(map[string]*attemptEntry), now: time.Now}
+}
+
+// the live entry for key, expired entries are dropped
+func (s *memoryAttemptStore) entry(key string) *attemptEntry {
+	e := s.entries[key]
+	if e != nil && s.now().After(e.expires) && s.now().After(e.locked) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+// drop every expired entry, at most once per attemptSweepInterval, so keys tried once do not stay forever
+func (s *memoryAttemptStore) sweep() {
+	if s.now().Sub(s.lastSweep) < attemptSweepInterval {
+		return
+	}
+	for key := range s.entries {
+		s.entry(key)
+	}
+	s.lastSweep = s.now()
+}
+
+func (s *memoryAttemptStore) Incr(key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+	e := s.entry(key)
+	if e == nil || s.now().After(e.expires) {
+		locked := time.Time{}
+		if e != nil {
+			locked = e.locked
+		}
+		e = &attemptEntry{expires: s.now().Add(window), locked: locked}
+		s.entries[key] = e
+	}
+	e.count++
+	return e.count, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+	e := s.entry(key)
+	if e == nil {
+		e = &attemptEntry{expires: s.now()}
+		s.entries[key] = e
+	}
+	e.locked = until
+	return nil
+}
+
+func (s *memoryAttemptStore) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entry(key); e != nil {
+		return e.locked, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// counters kept in Redis, the keys expire on their own
+type redisAttemptStore struct {
+	pool *redis.Pool
+}
+
+func (s redisAttemptStore) Incr(key string, window time.Duration) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("INCR", "attempts:"+key))
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		_, err = conn.Do("PEXPIRE", "attempts:"+key, window.Milliseconds())
+	}
+	return n, err
+}
+
+func (s redisAttemptStore) Lock(key string, until time.Time) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ttl := time.Until(until).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := conn.Do("SET", "locked:"+key, until.UnixMilli(), "PX", ttl)
+	return err
+}
+
+func (s redisAttemptStore) LockedUntil(key string) (time.Time, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("GET", "locked:"+key))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (s redisAttemptStore) Reset(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", "attempts:"+key, "locked:"+key)
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleBackoff(t *testing.T) {
+	th := throttle{free: 5, base: time.Second, max: time.Minute}
+
+	assert.Equal(t, time.Duration(0), th.backoff(5))
+	assert.Equal(t, time.Second, th.backoff(6))
+	assert.Equal(t, 2*time.Second, th.backoff(7))
+	assert.Equal(t, 32*time.Second, th.backoff(11))
+	assert.Equal(t, time.Minute, th.backoff(12))
+	assert.Equal(t, time.Minute, th.backoff(1000))
+}
+
+func TestThrottleLockout(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	store := newMemoryAttemptStore()
+	store.now = func() time.Time { return now }
+	th := throttle{store: store, free: 2, base: time.Second, max: time.Minute, window: time.Hour}
+
+	account, ip := accountKey(" A@Example.com"), ipKey("10.0.0.1")
+	assert.Equal(t, "account:a@example.com", account)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, th.fail(now, account, ip))
+	}
+	wait, _ := th.wait(now, account, ip)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// the third failure locks both keys out
+	assert.NoError(t, th.fail(now, account, ip))
+	wait, _ = th.wait(now, account, ip)
+	assert.Equal(t, time.Second, wait)
+
+	// other accounts from other addresses are not affected
+	wait, _ = th.wait(now, accountKey("b@example.com"), ipKey("10.0.0.2"))
+	assert.Equal(t, time.Duration(0), wait)
+
+	// success clears the account but not the address
+	assert.NoError(t, th.fail(now, account, ip))
+	assert.NoError(t, th.succeed(account))
+	wait, _ = th.wait(now, account)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, _ = th.wait(now, ip)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// counters start again once the window has passed
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, th.fail(now, ip))
+	wait, _ = th.wait(now, ip)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestPasswordResetThrottled(t *testing.T) {
+	defer func(store attemptStore) { loginThrottle.store = store }(loginThrottle.store)
+	loginThrottle.store = newMemoryAttemptStore()
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.POST("/requestPasswordReset", requestPasswordReset)
+
+	for i := 0; i < loginThrottle.free+1; i++ {
+		w := performRequest(router, http.MethodPost, "/requestPasswordReset", resetRequest{"throttle-test@example.com"})
+		assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+	}
+
+	w := performRequest(router, http.MethodPost, "/requestPasswordReset", resetRequest{"throttle-test@example.com"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// logging in is counted separately
+	wait, _ := loginThrottle.wait(time.Now(), accountKey("throttle-test@example.com"))
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestMemoryAttemptStoreSweep(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	store := newMemoryAttemptStore()
+	store.now = func() time.Time { return now }
+
+	store.Incr("ip:10.0.0.1", time.Minute)
+	store.Incr("ip:10.0.0.2", time.Hour)
+	store.Lock("ip:10.0.0.3", now.Add(2*time.Minute))
+	assert.Len(t, store.entries, 3)
+
+	// entries nobody tries again go once expired and unlocked
+	now = now.Add(90 * time.Second)
+	store.Incr("ip:10.0.0.4", time.Minute)
+	assert.Len(t, store.entries, 3)
+	assert.NotContains(t, store.entries, "ip:10.0.0.1")
+
+	now = now.Add(2 * time.Hour)
+	store.Incr("ip:10.0.0.4", time.Minute)
+	assert.Len(t, store.entries, 1)
+}
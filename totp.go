@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpIssuer = "Hot & Cold"
+	totpPeriod = 30
+	totpDigits = 6
+
+	// codes from this many periods before or after now are accepted, for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	// random bytes in a recovery code. at 128 bits a stored SHA-256 hash cannot be brute-forced,
+	// so no slow hash is needed
+	recoveryCodeBytes = 16
+
+	// how long the second step of a login may take, and how many codes may be tried
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type twoFactorRequest struct {
+	Id   string `json:"id"`
+	Pwd  string `json:"pwd"`
+	Code string `json:"code"`
+}
+
+type twoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type twoFactorChallenge struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code,omitempty"`
+	Device    string `json:"device,omitempty"`
+}
+
+// the RFC 6238 code for secret at time step
+func totpCode(secret []byte, step int64, digits int) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// the time step code was generated for, or false when it matches none near now
+func totpMatch(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(secret, step, totpDigits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpUri(email string, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + email)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// recovery codes look like 3f9a1c0e-27b4d5a8-9c31e0f2-6b7d8a45, they are compared without the
+// dashes and in lower case
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+	groups := make([]string, 0, len(code)/8)
+	for i := 0; i < len(code); i += 8 {
+		groups = append(groups, code[i:i+8])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// check a TOTP or unused recovery code of a user with 2FA enabled, a code is only accepted once
+func verifySecondFactor(tx *sql.Tx, userId string, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := tx.QueryRow(`
+		SELECT secret, last_step FROM totp WHERE id = $1 AND enabled FOR UPDATE;
+	`, userId).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totpMatch(key, strings.TrimSpace(code), time.Now()); ok {
+		if step <= lastStep {
+			return false, nil
+		}
+		_, err = tx.Exec(`UPDATE totp SET last_step = $2 WHERE id = $1;`, userId, step)
+		return err == nil, err
+	}
+
+	// shorter codes made before are no longer accepted, their hashes could be brute-forced
+	recovery := normalizeRecoveryCode(code)
+	if len(recovery) != 2*recoveryCodeBytes {
+		return false, nil
+	}
+	result, err := tx.Exec(`
+		UPDATE recovery_codes SET used_at = current_timestamp
+		WHERE id = $1 AND code_hash = $2 AND used_at IS NULL;
+	`, userId, hashToken(recovery))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// the challenge to complete a login with, empty when the user has no 2FA
+func startTwoFactor(userId int) (string, error) {
+	var enabled bool
+	err := dataBase.QueryRow(`SELECT enabled FROM totp WHERE id = $1;`, userId).Scan(&enabled)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	challenge, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dataBase.Exec(`
+		INSERT INTO login_challenges (token_hash, id, expires_at, attempts)
+		VALUES ($1, $2, $3, 0);
+	`, hashToken(challenge), userId, time.Now().Add(loginChallengeTTL))
+	return challenge, err
+}
+
+// create a new secret, it is not used until confirmed with a code
+func enrollTwoFactor(context *gin.Context) {
+	var request twoFactorRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	email, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	secret := totpEncoding.EncodeToString(key)
+
+	result, err := dataBase.Exec(`
+		INSERT INTO totp (id, secret, enabled, last_step)
+		VALUES ($1, $2, false, 0)
+		ON CONFLICT (id) DO UPDATE SET secret = $2, last_step = 0
+		WHERE NOT totp.enabled;
+	`, request.Id, secret)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		context.IndentedJSON(http.StatusConflict, newResponse("2fa already enabled", []interface{}{id{request.Id}}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{twoFactorEnrollment{secret, totpUri(email, secret)}}))
+}
+
+// turn on 2FA with a first code from the authenticator, returns the recovery codes once
+func confirmTwoFactor(context *gin.Context) {
+	var request twoFactorRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	defer tx.Rollback()
+
+	var secret string
+	err = tx.QueryRow(`SELECT secret FROM totp WHERE id = $1 AND NOT enabled FOR UPDATE;`, request.Id).Scan(&secret)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("no 2fa enrollment pending", []interface{}{id{request.Id}}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	step, ok := totpMatch(key, strings.TrimSpace(request.Code), time.Now())
+	if !ok {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid code", []interface{}{id{request.Id}}))
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE totp SET enabled = true, enabled_at = current_timestamp, last_step = $2 WHERE id = $1;`, request.Id, step)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM recovery_codes WHERE id = $1;`, request.Id)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if err != nil {
+			break
+		}
+		if codes[i], err = generateRecoveryCode(); err != nil {
+			break
+		}
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (id, code_hash) VALUES ($1, $2);
+		`, request.Id, hashToken(normalizeRecoveryCode(codes[i])))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{codes}))
+}
+
+// turn off 2FA, needs the password and a code
+func disableTwoFactor(context *gin.Context) {
+	var request twoFactorRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	_, ok := confirmPassword(context, request.Id, request.Pwd)
+	if !ok {
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err = verifySecondFactor(tx, request.Id, request.Code)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+	if !ok {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid code", []interface{}{id{request.Id}}))
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM totp WHERE id = $1;`, request.Id)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM recovery_codes WHERE id = $1;`, request.Id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{id{request.Id}}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{id{request.Id}}))
+}
+
+// second step of a login with 2FA, a code from the authenticator or a recovery code
+func loginTwoFactor(context *gin.Context) {
+	var request twoFactorChallenge
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	defer tx.Rollback()
+
+	var userId int
+	var email string
+	err = tx.QueryRow(`
+		UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND expires_at > current_timestamp AND attempts < $2
+		RETURNING id, (SELECT email FROM auth WHERE auth.id = login_challenges.id);
+	`, hashToken(request.Challenge), loginChallengeAttempts).Scan(&userId, &email)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusUnauthorized, newResponse("invalid or expired challenge", []interface{}{}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	// wrong codes lock the account out like wrong passwords, new challenges do not start the count again
+	key := accountKey(email)
+	if throttled(context, loginThrottle, key) {
+		return
+	}
+
+	ok, err := verifySecondFactor(tx, strconv.Itoa(userId), request.Code)
+	if err == nil && ok {
+		_, err = tx.Exec(`DELETE FROM login_challenges WHERE token_hash = $1;`, hashToken(request.Challenge))
+	}
+	// failed attempts are counted too
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	if !ok {
+		if err = loginThrottle.fail(time.Now(), key); err != nil {
+			log.Println(err)
+		}
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid code", []interface{}{}))
+		return
+	}
+
+	if err = loginThrottle.succeed(key); err != nil {
+		log.Println(err)
+	}
+	completeLogin(context, userId, request.Device)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// test vectors for SHA1 from RFC 6238 appendix B
+func TestTotpCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, code := range vectors {
+		assert.Equal(t, code, totpCode(secret, unix/totpPeriod, 8), "time %d", unix)
+	}
+}
+
+func TestTotpMatch(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	matched, ok := totpMatch(secret, totpCode(secret, step, totpDigits), now)
+	assert.True(t, ok)
+	assert.Equal(t, step, matched)
+
+	// one period of clock drift either way is accepted
+	_, ok = totpMatch(secret, totpCode(secret, step-1, totpDigits), now)
+	assert.True(t, ok)
+	_, ok = totpMatch(secret, totpCode(secret, step+1, totpDigits), now)
+	assert.True(t, ok)
+
+	_, ok = totpMatch(secret, totpCode(secret, step+2, totpDigits), now)
+	assert.False(t, ok)
+	_, ok = totpMatch(secret, "", now)
+	assert.False(t, ok)
+}
+
+func TestTotpUri(t *testing.T) {
+	uri := totpUri("a@example.com", "JBSWY3DPEHPK3PXP")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Hot%20&%20Cold:a@example.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Hot%20%26%20Cold")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := generateRecoveryCode()
+	assert.NoError(t, err)
+	assert.Len(t, code, 35)
+	assert.Len(t, strings.Split(code, "-"), 4)
+	assert.Len(t, normalizeRecoveryCode(code), 2*recoveryCodeBytes)
+
+	assert.Equal(t, normalizeRecoveryCode(code), normalizeRecoveryCode(" "+strings.ToUpper(code)+" "))
+	assert.Equal(t, strings.ReplaceAll(code, "-", ""), normalizeRecoveryCode(code))
+}
+
+func TestLoginTwoFactorThrottled(t *testing.T) {
+	requireDatabase(t)
+	defer func(th throttle) { loginThrottle = th }(loginThrottle)
+	loginThrottle.store = newMemoryAttemptStore()
+	loginThrottle.base = time.Minute
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.POST("/login", login)
+	router.POST("/login/2fa", loginTwoFactor)
+
+	userId := createTestUser(t, "totp-test-throttle@example.com")
+	secret := []byte("12345678901234567890")
+	_, err := dataBase.Exec(`INSERT INTO totp (id, secret, enabled) VALUES ($1, $2, true);`, userId, totpEncoding.EncodeToString(secret))
+	assert.NoError(t, err)
+	t.Cleanup(func() { dataBase.Exec(`DELETE FROM login_challenges WHERE id = $1;`, userId) })
+
+	challenge := func() string {
+		w := performRequest(router, http.MethodPost, "/login", auth{Email: "totp-test-throttle@example.com", Pwd: "password"})
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var body struct {
+			Body []twoFactorChallenge `json:"body"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Body[0].Challenge
+	}
+
+	// wrong codes count against the account across challenges, a correct password does not clear them
+	c := challenge()
+	for i := 0; i < loginChallengeAttempts; i++ {
+		w := performRequest(router, http.MethodPost, "/login/2fa", twoFactorChallenge{Challenge: c, Code: "000000"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	c = challenge()
+	w := performRequest(router, http.MethodPost, "/login/2fa", twoFactorChallenge{Challenge: c, Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// locked out, even with the right code
+	code := totpCode(secret, time.Now().Unix()/totpPeriod, totpDigits)
+	w = performRequest(router, http.MethodPost, "/login/2fa", twoFactorChallenge{Challenge: c, Code: code})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	w = performRequest(router, http.MethodPost, "/login", auth{Email: "totp-test-throttle@example.com", Pwd: "password"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type verifyRequest struct {
+	Token string `json:"token"`
+}
+
+// whether email is a single bare address such as a@example.com
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+func isVerified(userId string) (bool, error) {
+	var verified bool
+	err := dataBase.QueryRow(`SELECT verified FROM auth WHERE id = $1;`, userId).Scan(&verified)
+	return verified, err
+}
+
+// email a verification code for email to a user. a code for the account's current address replaces the earlier one
+// for it, and a code for a new address replaces the earlier ones for any new address, so a pending change leaves the
+// registration code working.
+func sendVerification(userId int, email string) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	// the account id has its own parameter, as auth and email_verifications ids differ in type
+	ttl := envDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	_, err = dataBase.Exec(`
+		WITH current AS (
+			SELECT email FROM auth WHERE id = $5
+		), cleared AS (
+			DELETE FROM email_verifications
+			WHERE id = $1
+			AND (email = $3 OR (email <> (SELECT email FROM current) AND $3 <> (SELECT email FROM current)))
+		)
+		INSERT INTO email_verifications (token_hash, id, email, expires_at, sent_at)
+		VALUES ($2, $1, $3, $4, current_timestamp);
+	`, userId, hashToken(token), email, time.Now().Add(ttl), userId)
+	if err != nil {
+		return err
+	}
+
+	body := "Welcome to Hot & Cold!\n\n" +
+		"Use this code to verify your email address, it expires in " + ttl.String() + ":\n\n" +
+		envOr("EMAIL_VERIFICATION_URL", "") + token + "\n"
+	return mailer.Send(email, "Verify your email", body)
+}
+
+// verify the address a code was sent to
+func verifyEmail(context *gin.Context) {
+	var request verifyRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	tx, err := dataBase.Begin()
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+	defer tx.Rollback()
+
+	var userId int
+	var email string
+	err = tx.QueryRow(`
+		DELETE FROM email_verifications
+		WHERE token_hash = $1 AND expires_at > current_timestamp
+		RETURNING id, email;
+	`, hashToken(request.Token)).Scan(&userId, &email)
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusBadRequest, newResponse("invalid or expired token", []interface{}{}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	var oldEmail string
+	err = tx.QueryRow(`SELECT email FROM auth WHERE id = $1 FOR UPDATE;`, userId).Scan(&oldEmail)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE auth SET verified = true, email = $2 WHERE id = $1;`, userId, email)
+	if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	// once the account moved, a code for the old address must not move it back
+	if email != oldEmail {
+		if _, err = tx.Exec(`DELETE FROM email_verifications WHERE id = $1;`, userId); err != nil {
+			context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{}))
+		return
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{id{strconv.Itoa(userId)}}))
+}
+
+// send the verification code again, at most once every VERIFICATION_RESEND_INTERVAL
+func resendVerification(context *gin.Context) {
+	var request resetRequest
+
+	if err := context.BindJSON(&request); err != nil {
+		return
+	}
+
+	// the address is either an unverified account's, or one an account is moving to
+	var userId int
+	var lastSent sql.NullTime
+	err := dataBase.QueryRow(`
+		SELECT auth.id, email_verifications.sent_at
+		FROM auth
+		LEFT JOIN email_verifications ON email_verifications.id = auth.id AND email_verifications.email = auth.email
+		WHERE auth.email = $1 AND NOT auth.verified
+		UNION ALL
+		SELECT email_verifications.id, email_verifications.sent_at
+		FROM email_verifications
+		JOIN auth ON auth.id = email_verifications.id
+		WHERE email_verifications.email = $1 AND auth.email <> email_verifications.email
+		ORDER BY sent_at DESC NULLS LAST
+		LIMIT 1;
+	`, request.Email).Scan(&userId, &lastSent)
+
+	// unknown and already verified addresses get the same answer
+	if err == sql.ErrNoRows {
+		context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+		return
+	} else if err != nil {
+		context.IndentedJSON(http.StatusOK, newResponse(err.Error(), []interface{}{request}))
+		return
+	}
+
+	interval := envDuration("VERIFICATION_RESEND_INTERVAL", time.Minute)
+	if lastSent.Valid {
+		if wait := time.Until(lastSent.Time.Add(interval)); wait > 0 {
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			context.IndentedJSON(http.StatusTooManyRequests, newResponse("verification email sent recently", []interface{}{request}))
+			return
+		}
+	}
+
+	if err = sendVerification(userId, request.Email); err != nil {
+		log.Println(err)
+	}
+
+	context.IndentedJSON(http.StatusOK, newResponse("ok", []interface{}{request}))
+}
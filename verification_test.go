@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidEmail(t *testing.T) {
+	assert.True(t, validEmail("a@example.com"))
+	assert.True(t, validEmail("first.last+tag@mail.example.com"))
+
+	assert.False(t, validEmail(""))
+	assert.False(t, validEmail("not an email"))
+	assert.False(t, validEmail("a@example.com, b@example.com"))
+	assert.False(t, validEmail("Alice <a@example.com>"))
+}
+
+// the code at the end of the last email sent to an address
+func mailedToken(t *testing.T, m *memoryMailer, to string) string {
+	t.Helper()
+
+	mail, ok := m.last(to)
+	if !ok {
+		t.Fatal("no email sent to ", to)
+	}
+	lines := strings.Split(strings.TrimSpace(mail.Body), "\n")
+	return strings.TrimPrefix(lines[len(lines)-1], envOr("EMAIL_VERIFICATION_URL", ""))
+}
+
+func verificationRouter() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.POST("/verifyEmail", verifyEmail)
+	router.POST("/resendVerification", resendVerification)
+	return router
+}
+
+func authEmail(t *testing.T, userId string) (string, bool) {
+	t.Helper()
+
+	var email string
+	var verified bool
+	if err := dataBase.QueryRow(`SELECT email, verified FROM auth WHERE id = $1;`, userId).Scan(&email, &verified); err != nil {
+		t.Fatal(err)
+	}
+	return email, verified
+}
+
+func TestVerifyEmailChange(t *testing.T) {
+	requireDatabase(t)
+	defer func(m Mailer) { mailer = m }(mailer)
+	m := &memoryMailer{}
+	mailer = m
+	router := verificationRouter()
+
+	userId := createTestUser(t, "verify-test@example.com")
+	_, err := dataBase.Exec(`UPDATE auth SET verified = false WHERE id = $1;`, userId)
+	assert.NoError(t, err)
+	numericId, _ := strconv.Atoi(userId)
+
+	// a change requested before the registration code is used leaves that code working
+	assert.NoError(t, sendVerification(numericId, "verify-test@example.com"))
+	registration := mailedToken(t, m, "verify-test@example.com")
+	assert.NoError(t, sendVerification(numericId, "verify-test-new@example.com"))
+
+	// the new address can ask for its code again
+	_, err = dataBase.Exec(`UPDATE email_verifications SET sent_at = sent_at - interval '1 hour' WHERE id = $1;`, userId)
+	assert.NoError(t, err)
+	first := mailedToken(t, m, "verify-test-new@example.com")
+	w := performRequest(router, http.MethodPost, "/resendVerification", resetRequest{"verify-test-new@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	change := mailedToken(t, m, "verify-test-new@example.com")
+	assert.NotEqual(t, first, change)
+	w = performRequest(router, http.MethodPost, "/resendVerification", resetRequest{"verify-test-new@example.com"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{registration})
+	assert.Equal(t, http.StatusOK, w.Code)
+	email, verified := authEmail(t, userId)
+	assert.Equal(t, "verify-test@example.com", email)
+	assert.True(t, verified)
+
+	// only the latest code for the new address works
+	w = performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{first})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{change})
+	assert.Equal(t, http.StatusOK, w.Code)
+	email, _ = authEmail(t, userId)
+	assert.Equal(t, "verify-test-new@example.com", email)
+}
+
+func TestVerifyEmailOldCode(t *testing.T) {
+	requireDatabase(t)
+	defer func(m Mailer) { mailer = m }(mailer)
+	m := &memoryMailer{}
+	mailer = m
+	router := verificationRouter()
+
+	userId := createTestUser(t, "verify-test-old@example.com")
+	_, err := dataBase.Exec(`UPDATE auth SET verified = false WHERE id = $1;`, userId)
+	assert.NoError(t, err)
+	numericId, _ := strconv.Atoi(userId)
+
+	assert.NoError(t, sendVerification(numericId, "verify-test-old@example.com"))
+	registration := mailedToken(t, m, "verify-test-old@example.com")
+	assert.NoError(t, sendVerification(numericId, "verify-test-moved@example.com"))
+
+	w := performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{mailedToken(t, m, "verify-test-moved@example.com")})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the account does not move back to the old address
+	w = performRequest(router, http.MethodPost, "/verifyEmail", verifyRequest{registration})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	email, verified := authEmail(t, userId)
+	assert.Equal(t, "verify-test-moved@example.com", email)
+	assert.True(t, verified)
+
+	// unknown and verified addresses get the same answer
+	for _, address := range []string{"verify-test-moved@example.com", "verify-test-nobody@example.com"} {
+		w = performRequest(router, http.MethodPost, "/resendVerification", resetRequest{address})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}